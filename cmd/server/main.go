@@ -1,17 +1,24 @@
 package main
 
 import (
+	"context"
 	"log"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 
 	"github.com/joho/godotenv"
 	"go.uber.org/zap"
 
+	"github.com/zeelrupapara/custom-ai-server/pkg/config"
 	"github.com/zeelrupapara/custom-ai-server/pkg/db"
 	"github.com/zeelrupapara/custom-ai-server/pkg/gpt"
 	"github.com/zeelrupapara/custom-ai-server/pkg/logger"
+	"github.com/zeelrupapara/custom-ai-server/pkg/mailer"
 	"github.com/zeelrupapara/custom-ai-server/pkg/migration"
 
+	"github.com/zeelrupapara/custom-ai-server/internal/handlers"
 	"github.com/zeelrupapara/custom-ai-server/internal/routes"
 )
 
@@ -24,6 +31,7 @@ func main() {
 	// 2. Init logger
 	logg, _ := logger.New()
 	defer logg.Sync()
+	zap.ReplaceGlobals(logg)
 	logg.Info("Starting custom-ai-server")
 
 	if err := migration.Up(); err != nil {
@@ -38,16 +46,65 @@ func main() {
 		logg.Fatal("Redis connect failed", zap.Error(err))
 	}
 
-	// 4. Load GPT configs
-	if err := gpt.LoadConfigs("configs/gpts"); err != nil {
+	if err := mailer.Setup(); err != nil {
+		logg.Fatal("Mailer setup failed", zap.Error(err))
+	}
+
+	// 4. Load GPT configs, then follow reloads on other replicas and,
+	// if enabled, edits to the files
+	cfg := config.Load()
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	if _, err := gpt.LoadConfigs(ctx, cfg.GPTConfigDir); err != nil {
 		logg.Fatal("Failed to load GPT configs", zap.Error(err))
 	}
+	go gpt.Subscribe(ctx, cfg.GPTConfigDir)
+	if cfg.GPTWatch {
+		go func() {
+			if err := gpt.Watch(ctx, cfg.GPTConfigDir, cfg.GPTWatchDebounce); err != nil {
+				logg.Error("GPT config watcher stopped", zap.Error(err))
+			}
+		}()
+	}
 
 	// 5. Start HTTP server & routes
 	app := routes.NewRouter(logg)
 	port := os.Getenv("PORT")
-	logg.Info("Listening", zap.String("port", port))
-	if err := app.Listen(":" + port); err != nil {
+
+	listenErr := make(chan error, 1)
+	go func() {
+		logg.Info("Listening", zap.String("port", port))
+		listenErr <- app.Listen(":" + port)
+	}()
+	select {
+	case err := <-listenErr:
 		logg.Fatal("Server failed", zap.Error(err))
+	case <-ctx.Done():
+		stop() // a second signal kills the process immediately
+	}
+
+	// 6. Graceful shutdown
+	timeout := config.Load().ShutdownTimeout
+	logg.Info("Shutting down", zap.Duration("timeout", timeout))
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// HTTP shutdown does not track hijacked WebSocket connections,
+	// so sockets and their runs are drained alongside it.
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		handlers.DrainWebSockets(shutdownCtx)
+	}()
+	if err := app.ShutdownWithContext(shutdownCtx); err != nil {
+		logg.Warn("HTTP shutdown incomplete", zap.Error(err))
+	}
+	wg.Wait()
+
+	db.ClosePostgres()
+	if err := db.CloseRedis(); err != nil {
+		logg.Warn("Redis close failed", zap.Error(err))
 	}
+	logg.Info("Shutdown complete")
 }
@@ -1,14 +1,36 @@
 package handlers
 
 import (
+	"strconv"
+
 	"github.com/gofiber/fiber/v2"
+	"github.com/zeelrupapara/custom-ai-server/pkg/auth"
+	"github.com/zeelrupapara/custom-ai-server/pkg/config"
 	"github.com/zeelrupapara/custom-ai-server/pkg/gpt"
 )
 
-// ReloadGPTs lets an admin reload all YAML configs
+// ReloadGPTs lets an admin reload all GPT configs, on every replica, and
+// answers with the GPTs added, changed and removed. Invalid configs are
+// rejected with every problem found and the loaded GPTs are left as they were.
 func ReloadGPTs(c *fiber.Ctx) error {
-	if err := gpt.LoadConfigs("configs/gpts"); err != nil {
-		return fiber.NewError(fiber.StatusInternalServerError, "reload failed")
+	diff, err := gpt.Reload(c.Context(), config.Load().GPTConfigDir, "admin", c.Locals("userID").(int))
+	if err != nil {
+		return gptConfigError(c, err)
+	}
+	auditLog(c, "gpt.reload", "gpt", "", map[string]any{"added": diff.Added, "changed": diff.Changed, "removed": diff.Removed})
+	return c.JSON(diff)
+}
+
+// RevokeUserSessions logs a user out of every session
+func RevokeUserSessions(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return fiber.ErrBadRequest
+	}
+	if err := auth.RevokeUserSessions(c.Context(), id); err != nil {
+		return fiber.ErrInternalServerError
 	}
-	return c.SendStatus(fiber.StatusOK)
+	CloseUserSockets(id, "session revoked")
+	auditLog(c, "user.revoke_sessions", "user", strconv.Itoa(id), nil)
+	return c.SendStatus(fiber.StatusNoContent)
 }
@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"maps"
+	"slices"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/zeelrupapara/custom-ai-server/pkg/auth"
+	"github.com/zeelrupapara/custom-ai-server/pkg/gpt"
+	"github.com/zeelrupapara/custom-ai-server/pkg/i18n"
+)
+
+// gptCapabilities are the tools ai.NewAI gives every assistant
+var gptCapabilities = []string{"file_search", "code_interpreter", "web_browser", "image_generation"}
+
+// gptInfo is what clients see of a GPT: enough to list it and start a chat
+type gptInfo struct {
+	Slug         string   `json:"slug"`
+	Name         string   `json:"name"`
+	Description  string   `json:"description"`
+	Icon         string   `json:"icon,omitempty"`
+	Starters     []string `json:"starters"`
+	Welcome      string   `json:"welcome"`
+	Capabilities []string `json:"capabilities"`
+	RateLimit    string   `json:"rate_limit,omitempty"`
+	// Locales are the languages the GPT's strings are translated into
+	Locales []string `json:"locales,omitempty"`
+}
+
+// infoGPT describes cfg with its strings in locale
+func infoGPT(cfg *gpt.GPTConfig, locale string) *gptInfo {
+	l := cfg.Localize(locale)
+	if l.Starters == nil {
+		l.Starters = []string{}
+	}
+	if l.Welcome == "" {
+		l.Welcome = i18n.T(locale, "ready", l.Name)
+	}
+	return &gptInfo{
+		Slug:         cfg.Slug,
+		Name:         l.Name,
+		Description:  l.Description,
+		Icon:         cfg.Icon,
+		Starters:     l.Starters,
+		Welcome:      l.Welcome,
+		Capabilities: gptCapabilities,
+		RateLimit:    cfg.RateLimit,
+		Locales:      slices.Sorted(maps.Keys(cfg.Locales)),
+	}
+}
+
+// ListCatalog handles GET /gpts: every GPT the caller may chat with,
+// ordered by slug, in the locale the caller asks for
+func ListCatalog(c *fiber.Ctx) error {
+	locale := requestLocale(c)
+	out := []*gptInfo{}
+	for _, cfg := range gpt.All() {
+		if auth.CanUseGPT(c, cfg) {
+			out = append(out, infoGPT(cfg, locale))
+		}
+	}
+	c.Vary(fiber.HeaderAcceptLanguage)
+	return c.JSON(out)
+}
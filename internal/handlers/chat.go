@@ -0,0 +1,204 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/zeelrupapara/custom-ai-server/pkg/ai"
+	"github.com/zeelrupapara/custom-ai-server/pkg/auth"
+	"github.com/zeelrupapara/custom-ai-server/pkg/dispatcher"
+	"github.com/zeelrupapara/custom-ai-server/pkg/i18n"
+	"github.com/zeelrupapara/custom-ai-server/pkg/org"
+	"go.uber.org/zap"
+)
+
+// sseKeepAlive is how often an idle SSE stream gets a comment line so
+// proxies do not time it out while the assistant is working.
+const sseKeepAlive = 15 * time.Second
+
+// Chat handles POST /v1/gpts/:slug/chat. It replies with JSON, or with a
+// Server-Sent Events stream when the body has "stream": true or the client
+// sends Accept: text/event-stream.
+func Chat(c *fiber.Ctx) error {
+	type req struct {
+		Message        string `json:"message"`
+		ConversationID string `json:"conversation_id"`
+		Stream         bool   `json:"stream"`
+	}
+	var body req
+	if err := c.BodyParser(&body); err != nil || strings.TrimSpace(body.Message) == "" {
+		return fiber.NewError(fiber.StatusBadRequest, i18n.T(requestLocale(c), "message_required"))
+	}
+	userID := c.Locals("userID").(int)
+	cfg, err := dispatcher.Lookup(c.Params("slug"))
+	if err != nil {
+		return chatError(c, err)
+	}
+	if !auth.CanUseGPT(c, cfg) {
+		return fiber.ErrForbidden
+	}
+	orgID, _ := auth.OrgOf(c)
+	if err := dispatcher.Admit(c.UserContext(), userID, orgID, cfg); err != nil {
+		return chatError(c, err)
+	}
+
+	r := dispatcher.Request{
+		Caller:         dispatcher.Caller{UserID: userID, OrgID: orgID, Locale: requestLocale(c)},
+		Slug:           cfg.Slug,
+		ConversationID: body.ConversationID,
+		Message:        body.Message,
+	}
+	if body.Stream || strings.Contains(c.Get(fiber.HeaderAccept), "text/event-stream") {
+		return streamChat(c, r)
+	}
+	reply, err := dispatcher.Send(c.UserContext(), r)
+	if err != nil {
+		return chatError(c, err)
+	}
+	return c.JSON(reply)
+}
+
+// streamChat answers over SSE: a "status" event, keep-alive comments while
+// the run is in progress, then a "reply" or "error" event. If the client
+// disconnects the upstream run is cancelled.
+func streamChat(c *fiber.Ctx, r dispatcher.Request) error {
+	c.Set(fiber.HeaderContentType, "text/event-stream")
+	c.Set(fiber.HeaderCacheControl, "no-cache")
+	c.Set(fiber.HeaderConnection, "keep-alive")
+	c.Set("X-Accel-Buffering", "no")
+
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		type result struct {
+			reply *dispatcher.Reply
+			err   error
+		}
+		done := make(chan result, 1)
+		go func() {
+			reply, err := dispatcher.Send(ctx, r)
+			done <- result{reply, err}
+		}()
+
+		writeEvent(w, "status", fiber.Map{"status": "running"})
+		ticker := time.NewTicker(sseKeepAlive)
+		defer ticker.Stop()
+		for {
+			select {
+			case res := <-done:
+				if res.err != nil {
+					code, _, retryable := errorCode(res.err)
+					msg, detail := errorText(res.err, code, r.Locale)
+					ev := fiber.Map{"code": code, "error": msg, "retryable": retryable}
+					if detail != "" {
+						ev["detail"] = detail
+					}
+					if res.reply != nil {
+						ev["conversation_id"] = res.reply.ConversationID
+					}
+					writeEvent(w, "error", ev)
+					return
+				}
+				writeEvent(w, "reply", res.reply)
+				return
+			case <-ticker.C:
+				if _, err := w.WriteString(": keep-alive\n\n"); err != nil || w.Flush() != nil {
+					cancel() // client went away; stop the upstream run
+					<-done
+					return
+				}
+			}
+		}
+	})
+	return nil
+}
+
+// writeEvent writes one SSE event with a JSON payload and flushes it
+func writeEvent(w *bufio.Writer, event string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// requestLocale is the ?locale= query parameter, else the Accept-Language
+// tag the client ranks highest, else "" for the default
+func requestLocale(c *fiber.Ctx) string {
+	if locale := c.Query("locale"); i18n.Valid(locale) {
+		return locale
+	}
+	return i18n.Preferred(c.Get(fiber.HeaderAcceptLanguage))
+}
+
+// chatError writes err as {"error","code"} with a matching HTTP status,
+// the message in the caller's locale
+func chatError(c *fiber.Ctx, err error) error {
+	code, status, _ := errorCode(err)
+	msg, detail := errorText(err, code, requestLocale(c))
+	body := fiber.Map{"error": msg, "code": code}
+	if detail != "" {
+		body["detail"] = detail
+	}
+	return c.Status(status).JSON(body)
+}
+
+// errorText is the catalog message for an error code in locale. detail is
+// err's own text when it says more than the message; errors without a
+// catalog entry are shown as they are. Internal errors are only logged, so
+// database and driver text never reaches the client.
+func errorText(err error, code, locale string) (msg, detail string) {
+	key := "error." + code
+	if code == "internal" {
+		zap.L().Error("request failed", zap.Error(err))
+		return i18n.T(locale, key), ""
+	}
+	msg, ok := i18n.Lookup(locale, key)
+	if !ok {
+		return err.Error(), ""
+	}
+	if detail = err.Error(); detail == i18n.T(i18n.Default, key) {
+		detail = ""
+	}
+	return msg, detail
+}
+
+// errorCode maps dispatcher and AI errors to the code clients see on every
+// transport, the HTTP status for it and whether retrying may help.
+func errorCode(err error) (code string, status int, retryable bool) {
+	var aerr *ai.Error
+	switch {
+	case errors.Is(err, dispatcher.ErrUnknownGPT):
+		return "unknown_gpt", fiber.StatusNotFound, false
+	case errors.Is(err, dispatcher.ErrConversationNotFound):
+		return "conversation_not_found", fiber.StatusNotFound, false
+	case errors.Is(err, dispatcher.ErrRateLimited):
+		return "rate_limited", fiber.StatusTooManyRequests, true
+	case errors.Is(err, dispatcher.ErrInvalidReply):
+		return "invalid_reply", fiber.StatusBadGateway, true
+	case errors.Is(err, org.ErrQuotaExceeded):
+		return "quota_exceeded", fiber.StatusTooManyRequests, false
+	case errors.As(err, &aerr):
+		status := fiber.StatusBadGateway
+		switch aerr.Kind {
+		case ai.KindRateLimited, ai.KindUpstreamUnavailable:
+			status = fiber.StatusServiceUnavailable
+		case ai.KindTimeout:
+			status = fiber.StatusGatewayTimeout
+		case ai.KindCancelled:
+			status = fiber.StatusRequestTimeout
+		}
+		return string(aerr.Kind), status, aerr.Retryable()
+	}
+	return "internal", fiber.StatusInternalServerError, false
+}
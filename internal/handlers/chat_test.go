@@ -0,0 +1,32 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/zeelrupapara/custom-ai-server/pkg/dispatcher"
+)
+
+func TestErrorText(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantDetail bool
+	}{
+		{"internal hides cause", errors.New(`relation "messages" does not exist`), false},
+		{"invalid reply keeps detail", fmt.Errorf("%w: field answer missing", dispatcher.ErrInvalidReply), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, _ := errorCode(tt.err)
+			msg, detail := errorText(tt.err, code, "en")
+			if msg == tt.err.Error() {
+				t.Errorf("msg is the raw error %q", msg)
+			}
+			if (detail != "") != tt.wantDetail {
+				t.Errorf("detail = %q, want detail %v", detail, tt.wantDetail)
+			}
+		})
+	}
+}
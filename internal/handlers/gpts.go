@@ -0,0 +1,278 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5"
+
+	"github.com/zeelrupapara/custom-ai-server/pkg/config"
+	"github.com/zeelrupapara/custom-ai-server/pkg/gpt"
+)
+
+// gptView is a loaded GPT, where it was defined and its version
+type gptView struct {
+	*gpt.GPTConfig
+	Source  string `json:"source"`
+	Version int    `json:"version"`
+}
+
+func viewGPT(cfg *gpt.GPTConfig) gptView {
+	return gptView{GPTConfig: cfg, Source: cfg.Source, Version: cfg.Version}
+}
+
+// ListGPTs handles GET /admin/gpts: every loaded GPT, from files and the
+// database
+func ListGPTs(c *fiber.Ctx) error {
+	all := gpt.All()
+	views := make([]gptView, len(all))
+	for i, cfg := range all {
+		views[i] = viewGPT(cfg)
+	}
+	return c.JSON(views)
+}
+
+// GetGPT handles GET /admin/gpts/:slug
+func GetGPT(c *fiber.Ctx) error {
+	cfg, ok := gpt.Get(c.Params("slug"))
+	if !ok {
+		return fiber.ErrNotFound
+	}
+	return c.JSON(viewGPT(cfg))
+}
+
+// CreateGPT handles POST /admin/gpts with a definition in the config file
+// format (JSON or YAML). A GPT defined by a file can be taken over by
+// creating one with its slug.
+func CreateGPT(c *fiber.Ctx) error {
+	cfg, def, err := parseGPTBody(c)
+	if err != nil {
+		return gptConfigError(c, err)
+	}
+	actor := c.Locals("userID").(int)
+	_, err = gpt.Update(c.Context(), config.Load().GPTConfigDir, actor, func(tx pgx.Tx) error {
+		_, err := tx.Exec(c.Context(),
+			`INSERT INTO gpts(slug,definition,created_by,updated_by) VALUES($1,$2,$3,$3)`, cfg.Slug, def, actor)
+		return err
+	})
+	if err != nil {
+		return gptConfigError(c, err)
+	}
+	auditLog(c, "gpt.create", "gpt", cfg.Slug, nil)
+	return c.Status(fiber.StatusCreated).JSON(loadedGPT(cfg.Slug))
+}
+
+// UpdateGPT handles PUT /admin/gpts/:slug, replacing a stored definition.
+// The slug can't change.
+func UpdateGPT(c *fiber.Ctx) error {
+	cfg, def, err := parseGPTBody(c)
+	if err != nil {
+		return gptConfigError(c, err)
+	}
+	if cfg.Slug != c.Params("slug") {
+		return fiber.NewError(fiber.StatusBadRequest, "slug doesn't match the URL")
+	}
+	actor := c.Locals("userID").(int)
+	_, err = gpt.Update(c.Context(), config.Load().GPTConfigDir, actor, func(tx pgx.Tx) error {
+		return execStored(c.Context(), tx,
+			`UPDATE gpts SET definition=$2, updated_by=$3, updated_at=NOW() WHERE slug=$1`, cfg.Slug, def, actor)
+	})
+	if err != nil {
+		return gptConfigError(c, err)
+	}
+	auditLog(c, "gpt.update", "gpt", cfg.Slug, nil)
+	return c.JSON(loadedGPT(cfg.Slug))
+}
+
+// DeleteGPT handles DELETE /admin/gpts/:slug. If a file defines the same
+// slug, that definition is used again.
+func DeleteGPT(c *fiber.Ctx) error {
+	slug := c.Params("slug")
+	actor := c.Locals("userID").(int)
+	_, err := gpt.Update(c.Context(), config.Load().GPTConfigDir, actor, func(tx pgx.Tx) error {
+		return execStored(c.Context(), tx, `DELETE FROM gpts WHERE slug=$1`, slug)
+	})
+	if err != nil {
+		return gptConfigError(c, err)
+	}
+	auditLog(c, "gpt.delete", "gpt", slug, nil)
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// ImportGPTs handles POST /admin/gpts/import, storing every GPT that is
+// only defined by a file. Stored GPTs are left alone.
+func ImportGPTs(c *fiber.Ctx) error {
+	actor := c.Locals("userID").(int)
+	imported := []string{}
+	_, err := gpt.Update(c.Context(), config.Load().GPTConfigDir, actor, func(tx pgx.Tx) error {
+		for _, cfg := range gpt.All() {
+			if cfg.Source != gpt.SourceFile {
+				continue
+			}
+			tag, err := tx.Exec(c.Context(),
+				`INSERT INTO gpts(slug,definition,created_by,updated_by) VALUES($1,$2,$3,$3) ON CONFLICT DO NOTHING`,
+				cfg.Slug, cfg.Definition(), actor)
+			if err != nil {
+				return err
+			}
+			if tag.RowsAffected() > 0 {
+				imported = append(imported, cfg.Slug)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return gptConfigError(c, err)
+	}
+	auditLog(c, "gpt.import", "gpt", "", map[string]any{"imported": imported})
+	return c.JSON(fiber.Map{"imported": imported})
+}
+
+// ListGPTVersions handles GET /admin/gpts/:slug/versions, newest first
+func ListGPTVersions(c *fiber.Ctx) error {
+	versions, err := gpt.ListVersions(c.Context(), c.Params("slug"))
+	if err != nil {
+		return fiber.ErrInternalServerError
+	}
+	if len(versions) == 0 {
+		return fiber.ErrNotFound
+	}
+	return c.JSON(versions)
+}
+
+// GetGPTVersion handles GET /admin/gpts/:slug/versions/:version
+func GetGPTVersion(c *fiber.Ctx) error {
+	n, err := c.ParamsInt("version")
+	if err != nil || n <= 0 {
+		return fiber.ErrBadRequest
+	}
+	v, err := gptVersion(c, n)
+	if err != nil {
+		return err
+	}
+	return c.JSON(v)
+}
+
+// DiffGPTVersions handles GET /admin/gpts/:slug/diff?from=&to=. to
+// defaults to the latest version and from to the one before it.
+func DiffGPTVersions(c *fiber.Ctx) error {
+	to, err := gptVersion(c, c.QueryInt("to"))
+	if err != nil {
+		return err
+	}
+	fromN := c.QueryInt("from", to.Version-1)
+	if fromN <= 0 {
+		return fiber.NewError(fiber.StatusBadRequest, "from is required when there is no earlier version")
+	}
+	from, err := gptVersion(c, fromN)
+	if err != nil {
+		return err
+	}
+	changes, err := gpt.DiffDefinitions(from.Definition, to.Definition)
+	if err != nil {
+		return fiber.ErrInternalServerError
+	}
+	return c.JSON(fiber.Map{"slug": to.Slug, "from": from.Version, "to": to.Version, "changes": changes})
+}
+
+// RollbackGPT handles POST /admin/gpts/:slug/rollback {"version"}. The old
+// definition is stored as the GPT's current one, which records it as a new
+// version; a GPT defined by a file is taken over by the database.
+func RollbackGPT(c *fiber.Ctx) error {
+	type req struct {
+		Version int `json:"version"`
+	}
+	var body req
+	if err := c.BodyParser(&body); err != nil || body.Version <= 0 {
+		return fiber.NewError(fiber.StatusBadRequest, "version is required")
+	}
+	v, err := gptVersion(c, body.Version)
+	if err != nil {
+		return err
+	}
+	// the partials recorded with the version are for reference only
+	var def map[string]any
+	if err := json.Unmarshal(v.Definition, &def); err != nil {
+		return fiber.ErrInternalServerError
+	}
+	delete(def, "partials")
+	stored, err := json.Marshal(def)
+	if err != nil {
+		return fiber.ErrInternalServerError
+	}
+	actor := c.Locals("userID").(int)
+	_, err = gpt.Update(c.Context(), config.Load().GPTConfigDir, actor, func(tx pgx.Tx) error {
+		_, err := tx.Exec(c.Context(),
+			`INSERT INTO gpts(slug,definition,created_by,updated_by) VALUES($1,$2,$3,$3)
+			 ON CONFLICT (slug) DO UPDATE SET definition=EXCLUDED.definition, updated_by=EXCLUDED.updated_by, updated_at=NOW()`,
+			v.Slug, stored, actor)
+		return err
+	})
+	if err != nil {
+		return gptConfigError(c, err)
+	}
+	auditLog(c, "gpt.rollback", "gpt", v.Slug, map[string]any{"to_version": v.Version})
+	return c.JSON(loadedGPT(v.Slug))
+}
+
+// gptVersion loads version n (0 for the latest) of :slug
+func gptVersion(c *fiber.Ctx, n int) (*gpt.VersionInfo, error) {
+	v, err := gpt.GetVersion(c.Context(), c.Params("slug"), n)
+	if errors.Is(err, gpt.ErrVersionNotFound) {
+		return nil, fiber.NewError(fiber.StatusNotFound, err.Error())
+	}
+	if err != nil {
+		return nil, fiber.ErrInternalServerError
+	}
+	return v, nil
+}
+
+// parseGPTBody validates the request body as a GPT definition and returns
+// it normalized to JSON for storage
+func parseGPTBody(c *fiber.Ctx) (*gpt.GPTConfig, []byte, error) {
+	cfg, err := gpt.ParseDefinition(config.Load().GPTConfigDir, "request body", c.Body())
+	if err != nil {
+		return nil, nil, err
+	}
+	return cfg, cfg.Definition(), nil
+}
+
+// execStored runs a statement on one stored GPT, 404 when there is none
+func execStored(ctx context.Context, tx pgx.Tx, sql string, args ...any) error {
+	tag, err := tx.Exec(ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return fiber.NewError(fiber.StatusNotFound, "no stored GPT with that slug")
+	}
+	return nil
+}
+
+// loadedGPT views the GPT just written
+func loadedGPT(slug string) any {
+	if cfg, ok := gpt.Get(slug); ok {
+		return viewGPT(cfg)
+	}
+	return fiber.Map{"slug": slug}
+}
+
+// gptConfigError answers a failed load or write: invalid definitions with
+// 422 and every problem found, anything else through dbError.
+func gptConfigError(c *fiber.Ctx, err error) error {
+	var verr gpt.ValidationError
+	if errors.As(err, &verr) {
+		details := make([]fiber.Map, len(verr))
+		for i, e := range verr {
+			details[i] = fiber.Map{"file": e.File, "line": e.Line, "field": e.Field, "message": e.Msg}
+		}
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{"error": "invalid GPT config", "details": details})
+	}
+	var ferr *fiber.Error
+	if errors.As(err, &ferr) {
+		return ferr
+	}
+	return dbError(err)
+}
@@ -0,0 +1,228 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/zeelrupapara/custom-ai-server/pkg/ai"
+	"github.com/zeelrupapara/custom-ai-server/pkg/auth"
+	"github.com/zeelrupapara/custom-ai-server/pkg/dispatcher"
+	"github.com/zeelrupapara/custom-ai-server/pkg/gpt"
+)
+
+// ListModels handles GET /v1/models, listing every GPT slug the caller
+// may use as a model. Restricted GPTs the caller lacks a role for are hidden.
+func ListModels(c *fiber.Ctx) error {
+	data := []fiber.Map{}
+	for _, cfg := range gpt.All() {
+		if auth.CanUseGPT(c, cfg) {
+			data = append(data, fiber.Map{"id": cfg.Slug, "object": "model", "created": 0, "owned_by": "custom-ai-server"})
+		}
+	}
+	return c.JSON(fiber.Map{"object": "list", "data": data})
+}
+
+// compatMessage is a chat-completions message; content is either a string
+// or an array of {"type":"text","text":...} parts.
+type compatMessage struct {
+	Role    string          `json:"role"`
+	Content json.RawMessage `json:"content"`
+}
+
+func (m compatMessage) text() string {
+	var s string
+	if json.Unmarshal(m.Content, &s) == nil {
+		return s
+	}
+	var parts []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}
+	json.Unmarshal(m.Content, &parts)
+	var b strings.Builder
+	for _, p := range parts {
+		if p.Type == "text" {
+			b.WriteString(p.Text)
+		}
+	}
+	return b.String()
+}
+
+// ChatCompletions handles POST /v1/chat/completions so OpenAI SDKs can
+// talk to a GPT by passing its slug as the model. The GPT's system prompt,
+// files and tools apply; client system messages are added on top.
+func ChatCompletions(c *fiber.Ctx) error {
+	type req struct {
+		Model    string          `json:"model"`
+		Messages []compatMessage `json:"messages"`
+		Stream   bool            `json:"stream"`
+	}
+	var body req
+	if err := c.BodyParser(&body); err != nil {
+		return compatError(c, fiber.StatusBadRequest, "invalid_request_error", "invalid_body", "request body is not valid JSON")
+	}
+	cfg, err := dispatcher.Lookup(body.Model)
+	if err != nil || !auth.CanUseGPT(c, cfg) {
+		return compatError(c, fiber.StatusNotFound, "invalid_request_error", "model_not_found",
+			fmt.Sprintf("The model %q does not exist", body.Model))
+	}
+
+	var (
+		msgs         []ai.Message
+		instructions []string
+	)
+	for _, m := range body.Messages {
+		switch m.Role {
+		case "system", "developer":
+			instructions = append(instructions, m.text())
+		case "user", "assistant":
+			msgs = append(msgs, ai.Message{Role: m.Role, Content: m.text()})
+		}
+	}
+	if len(msgs) == 0 || msgs[len(msgs)-1].Role != "user" {
+		return compatError(c, fiber.StatusBadRequest, "invalid_request_error", "invalid_messages",
+			"messages must end with a user message")
+	}
+
+	orgID, _ := auth.OrgOf(c)
+	caller := dispatcher.Caller{UserID: c.Locals("userID").(int), OrgID: orgID, Locale: requestLocale(c)}
+	if err := dispatcher.Admit(c.UserContext(), caller.UserID, orgID, cfg); err != nil {
+		return compatFromErr(c, err)
+	}
+
+	id := completionID()
+	created := time.Now().Unix()
+	if body.Stream {
+		return streamCompletion(c, cfg, caller, id, created, msgs, strings.Join(instructions, "\n\n"))
+	}
+	reply, usage, err := dispatcher.Complete(c.UserContext(), cfg, caller, msgs, strings.Join(instructions, "\n\n"))
+	if err != nil {
+		return compatFromErr(c, err)
+	}
+	return c.JSON(fiber.Map{
+		"id":      id,
+		"object":  "chat.completion",
+		"created": created,
+		"model":   cfg.Slug,
+		"choices": []fiber.Map{{
+			"index":         0,
+			"message":       fiber.Map{"role": "assistant", "content": reply},
+			"finish_reason": "stop",
+		}},
+		"usage": usage,
+	})
+}
+
+// streamCompletion answers in chat.completion.chunk SSE frames ending with
+// "data: [DONE]". The reply arrives as a single content chunk.
+func streamCompletion(c *fiber.Ctx, cfg *gpt.GPTConfig, caller dispatcher.Caller, id string, created int64, msgs []ai.Message, instructions string) error {
+	c.Set(fiber.HeaderContentType, "text/event-stream")
+	c.Set(fiber.HeaderCacheControl, "no-cache")
+	c.Set(fiber.HeaderConnection, "keep-alive")
+	c.Set("X-Accel-Buffering", "no")
+
+	chunk := func(delta fiber.Map, finish any) fiber.Map {
+		return fiber.Map{
+			"id":      id,
+			"object":  "chat.completion.chunk",
+			"created": created,
+			"model":   cfg.Slug,
+			"choices": []fiber.Map{{"index": 0, "delta": delta, "finish_reason": finish}},
+		}
+	}
+
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		type result struct {
+			reply string
+			err   error
+		}
+		done := make(chan result, 1)
+		go func() {
+			reply, _, err := dispatcher.Complete(ctx, cfg, caller, msgs, instructions)
+			done <- result{reply, err}
+		}()
+
+		writeData(w, chunk(fiber.Map{"role": "assistant", "content": ""}, nil))
+		ticker := time.NewTicker(sseKeepAlive)
+		defer ticker.Stop()
+		for {
+			select {
+			case res := <-done:
+				if res.err != nil {
+					code, _, _ := errorCode(res.err)
+					writeData(w, fiber.Map{"error": fiber.Map{"message": compatErrorText(res.err, code, caller.Locale), "type": "api_error", "code": code}})
+				} else {
+					writeData(w, chunk(fiber.Map{"content": res.reply}, nil))
+					writeData(w, chunk(fiber.Map{}, "stop"))
+				}
+				fmt.Fprint(w, "data: [DONE]\n\n")
+				w.Flush()
+				return
+			case <-ticker.C:
+				if _, err := w.WriteString(": keep-alive\n\n"); err != nil || w.Flush() != nil {
+					cancel() // client went away; stop the upstream run
+					<-done
+					return
+				}
+			}
+		}
+	})
+	return nil
+}
+
+// writeData writes one unnamed SSE event with a JSON payload and flushes it
+func writeData(w *bufio.Writer, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "data: %s\n\n", data); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// compatError writes an OpenAI-shaped error body
+func compatError(c *fiber.Ctx, status int, typ, code, msg string) error {
+	return c.Status(status).JSON(fiber.Map{"error": fiber.Map{"message": msg, "type": typ, "param": nil, "code": code}})
+}
+
+// compatFromErr maps dispatcher/AI errors onto OpenAI error types
+func compatFromErr(c *fiber.Ctx, err error) error {
+	code, status, _ := errorCode(err)
+	typ := "api_error"
+	switch {
+	case status == fiber.StatusTooManyRequests:
+		typ = "rate_limit_error"
+	case status < 500:
+		typ = "invalid_request_error"
+	}
+	return compatError(c, status, typ, code, compatErrorText(err, code, requestLocale(c)))
+}
+
+// compatErrorText is err's message in locale followed by its detail, since
+// OpenAI error bodies have a single message
+func compatErrorText(err error, code, locale string) string {
+	msg, detail := errorText(err, code, locale)
+	if detail != "" {
+		msg += ": " + detail
+	}
+	return msg
+}
+
+// completionID returns a chat-completions style id
+func completionID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return "chatcmpl-" + hex.EncodeToString(b)
+}
@@ -0,0 +1,386 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5"
+
+	"github.com/zeelrupapara/custom-ai-server/pkg/auth"
+	"github.com/zeelrupapara/custom-ai-server/pkg/db"
+	"github.com/zeelrupapara/custom-ai-server/pkg/org"
+)
+
+var orgSlugPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{1,62}$`)
+
+type orgMember struct {
+	ID       int    `json:"id"`
+	Username string `json:"username"`
+	Role     string `json:"role"`
+}
+
+// orgBody is the create/update payload. On update a quota of 0 removes it.
+type orgBody struct {
+	Slug            string  `json:"slug"`
+	Name            *string `json:"name"`
+	MaxUsers        *int    `json:"max_users"`
+	MonthlyMessages *int    `json:"monthly_messages"`
+	MaxUploadBytes  *int64  `json:"max_upload_bytes"`
+}
+
+// ListOrgs handles GET /admin/orgs
+func ListOrgs(c *fiber.Ctx) error {
+	orgs, err := org.List(c.Context())
+	if err != nil {
+		return fiber.ErrInternalServerError
+	}
+	return c.JSON(orgs)
+}
+
+// CreateOrg handles POST /admin/orgs
+func CreateOrg(c *fiber.Ctx) error {
+	var body orgBody
+	if err := c.BodyParser(&body); err != nil {
+		return fiber.ErrBadRequest
+	}
+	if !orgSlugPattern.MatchString(body.Slug) {
+		return fiber.NewError(fiber.StatusBadRequest, "slug must be 2-63 lowercase letters, digits or dashes")
+	}
+	if body.Name == nil || strings.TrimSpace(*body.Name) == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "name is required")
+	}
+	var id int
+	err := db.PG.QueryRow(c.Context(),
+		`INSERT INTO organizations(slug,name,max_users,monthly_messages,max_upload_bytes)
+		 VALUES($1,$2,$3,$4,$5) RETURNING id`,
+		body.Slug, strings.TrimSpace(*body.Name), positive(body.MaxUsers), positive(body.MonthlyMessages), positive(body.MaxUploadBytes)).
+		Scan(&id)
+	if err != nil {
+		return dbError(err)
+	}
+	auditLog(c, "org.create", "org", strconv.Itoa(id), map[string]any{"slug": body.Slug})
+	o, err := org.Get(c.Context(), id)
+	if err != nil {
+		return fiber.ErrInternalServerError
+	}
+	return c.Status(fiber.StatusCreated).JSON(o)
+}
+
+// GetOrg handles GET /admin/orgs/:org
+func GetOrg(c *fiber.Ctx) error {
+	o, err := targetOrg(c)
+	if err != nil {
+		return err
+	}
+	return orgDetails(c, o)
+}
+
+// UpdateOrg handles PATCH /admin/orgs/:org: name and quotas
+func UpdateOrg(c *fiber.Ctx) error {
+	o, err := targetOrg(c)
+	if err != nil {
+		return err
+	}
+	var body orgBody
+	if err := c.BodyParser(&body); err != nil {
+		return fiber.ErrBadRequest
+	}
+	if body.Name != nil {
+		if strings.TrimSpace(*body.Name) == "" {
+			return fiber.NewError(fiber.StatusBadRequest, "name can't be empty")
+		}
+		o.Name = strings.TrimSpace(*body.Name)
+	}
+	if body.MaxUsers != nil {
+		o.MaxUsers = positive(body.MaxUsers)
+	}
+	if body.MonthlyMessages != nil {
+		o.MonthlyMessages = positive(body.MonthlyMessages)
+	}
+	if body.MaxUploadBytes != nil {
+		o.MaxUploadBytes = positive(body.MaxUploadBytes)
+	}
+	_, err = db.PG.Exec(c.Context(),
+		`UPDATE organizations SET name=$1, max_users=$2, monthly_messages=$3, max_upload_bytes=$4 WHERE id=$5`,
+		o.Name, o.MaxUsers, o.MonthlyMessages, o.MaxUploadBytes, o.ID)
+	if err != nil {
+		return fiber.ErrInternalServerError
+	}
+	auditLog(c, "org.update", "org", strconv.Itoa(o.ID), map[string]any{
+		"name": o.Name, "max_users": o.MaxUsers, "monthly_messages": o.MonthlyMessages, "max_upload_bytes": o.MaxUploadBytes,
+	})
+	return c.JSON(o)
+}
+
+// DeleteOrg handles DELETE /admin/orgs/:org. Members must be moved out
+// first; the organization's conversations and upload records go with it.
+func DeleteOrg(c *fiber.Ctx) error {
+	o, err := targetOrg(c)
+	if err != nil {
+		return err
+	}
+	tag, err := db.PG.Exec(c.Context(),
+		`DELETE FROM organizations o WHERE o.id=$1 AND NOT EXISTS(SELECT 1 FROM users WHERE org_id=o.id)`, o.ID)
+	if err != nil {
+		return fiber.ErrInternalServerError
+	}
+	if tag.RowsAffected() == 0 {
+		return fiber.NewError(fiber.StatusConflict, "organization still has members")
+	}
+	auditLog(c, "org.delete", "org", strconv.Itoa(o.ID), map[string]any{"slug": o.Slug})
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// ListOrgMembers handles GET /admin/orgs/:org/members
+func ListOrgMembers(c *fiber.Ctx) error {
+	o, err := targetOrg(c)
+	if err != nil {
+		return err
+	}
+	return membersOf(c, o.ID)
+}
+
+// SetOrgMember handles PUT /admin/orgs/:org/members/:id {"role"}. It adds
+// the user to the organization, moving them out of any other one, or
+// changes their role there.
+func SetOrgMember(c *fiber.Ctx) error {
+	o, err := targetOrg(c)
+	if err != nil {
+		return err
+	}
+	id, err := targetUser(c)
+	if err != nil {
+		return err
+	}
+	role, err := memberRole(c)
+	if err != nil {
+		return err
+	}
+	if err := setMembership(c.Context(), id, o, role); err != nil {
+		return err
+	}
+	auditLog(c, "org.set_member", "user", strconv.Itoa(id), map[string]any{"org": o.Slug, "role": role})
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// RemoveOrgMember handles DELETE /admin/orgs/:org/members/:id
+func RemoveOrgMember(c *fiber.Ctx) error {
+	o, err := targetOrg(c)
+	if err != nil {
+		return err
+	}
+	return removeMember(c, o)
+}
+
+// MyOrg handles GET /org: the caller's organization and its usage
+func MyOrg(c *fiber.Ctx) error {
+	o, err := callerOrg(c)
+	if err != nil {
+		return err
+	}
+	return orgDetails(c, o)
+}
+
+// MyOrgMembers handles GET /org/members
+func MyOrgMembers(c *fiber.Ctx) error {
+	id, _ := auth.OrgOf(c)
+	return membersOf(c, id)
+}
+
+// SetMyOrgMemberRole handles PUT /org/members/:id/role {"role"}. Org admins
+// can only change roles of users already in their organization.
+func SetMyOrgMemberRole(c *fiber.Ctx) error {
+	o, err := callerOrg(c)
+	if err != nil {
+		return err
+	}
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return fiber.ErrBadRequest
+	}
+	role, err := memberRole(c)
+	if err != nil {
+		return err
+	}
+	if id == c.Locals("userID").(int) && role != org.RoleAdmin {
+		return fiber.NewError(fiber.StatusConflict, "org admins can't demote themselves")
+	}
+	tag, err := db.PG.Exec(c.Context(),
+		`UPDATE users SET org_role=$1, token_version=token_version+1 WHERE id=$2 AND org_id=$3 AND org_role<>$1`,
+		role, id, o.ID)
+	if err != nil {
+		return fiber.ErrInternalServerError
+	}
+	if tag.RowsAffected() == 0 {
+		var member bool
+		db.PG.QueryRow(c.Context(), `SELECT EXISTS(SELECT 1 FROM users WHERE id=$1 AND org_id=$2)`, id, o.ID).Scan(&member)
+		if !member {
+			return fiber.ErrNotFound
+		}
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+	CloseUserSockets(id, "organization role changed")
+	auditLog(c, "org.set_member", "user", strconv.Itoa(id), map[string]any{"org": o.Slug, "role": role})
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// RemoveMyOrgMember handles DELETE /org/members/:id
+func RemoveMyOrgMember(c *fiber.Ctx) error {
+	o, err := callerOrg(c)
+	if err != nil {
+		return err
+	}
+	if id, _ := c.ParamsInt("id"); id == c.Locals("userID").(int) {
+		return fiber.NewError(fiber.StatusConflict, "org admins can't remove themselves")
+	}
+	return removeMember(c, o)
+}
+
+// setMembership puts userID in o with role, enforcing max_users. The user's
+// sessions are revoked so their tokens pick up the new org claim.
+func setMembership(ctx context.Context, userID int, o *org.Org, role string) error {
+	tx, err := db.PG.Begin(ctx)
+	if err != nil {
+		return fiber.ErrInternalServerError
+	}
+	defer tx.Rollback(ctx)
+	// lock the organization so concurrent adds can't overshoot max_users
+	var maxUsers *int
+	if err := tx.QueryRow(ctx, `SELECT max_users FROM organizations WHERE id=$1 FOR UPDATE`, o.ID).Scan(&maxUsers); err != nil {
+		return dbError(err)
+	}
+	var current *int
+	if err := tx.QueryRow(ctx, `SELECT org_id FROM users WHERE id=$1`, userID).Scan(&current); err != nil {
+		return dbError(err)
+	}
+	moving := current == nil || *current != o.ID
+	if moving && maxUsers != nil {
+		var members int
+		if err := tx.QueryRow(ctx, `SELECT COUNT(*) FROM users WHERE org_id=$1`, o.ID).Scan(&members); err != nil {
+			return fiber.ErrInternalServerError
+		}
+		if members >= *maxUsers {
+			return fiber.NewError(fiber.StatusConflict, "organization user quota reached")
+		}
+	}
+	tag, err := tx.Exec(ctx,
+		`UPDATE users SET org_id=$1, org_role=$2, token_version=token_version+1
+		 WHERE id=$3 AND (org_id IS DISTINCT FROM $1 OR org_role<>$2)`, o.ID, role, userID)
+	if err != nil {
+		return fiber.ErrInternalServerError
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fiber.ErrInternalServerError
+	}
+	if tag.RowsAffected() > 0 {
+		if moving {
+			// file context belongs to the old organization
+			db.RDB.Del(ctx, fmt.Sprintf("filectx:%d", userID))
+		}
+		CloseUserSockets(userID, "organization changed")
+	}
+	return nil
+}
+
+// removeMember takes :id out of o, revoking their sessions
+func removeMember(c *fiber.Ctx, o *org.Org) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return fiber.ErrBadRequest
+	}
+	tag, err := db.PG.Exec(c.Context(),
+		`UPDATE users SET org_id=NULL, org_role=$1, token_version=token_version+1 WHERE id=$2 AND org_id=$3`,
+		org.RoleMember, id, o.ID)
+	if err != nil {
+		return fiber.ErrInternalServerError
+	}
+	if tag.RowsAffected() == 0 {
+		return fiber.ErrNotFound
+	}
+	db.RDB.Del(c.Context(), fmt.Sprintf("filectx:%d", id))
+	CloseUserSockets(id, "removed from organization")
+	auditLog(c, "org.remove_member", "user", strconv.Itoa(id), map[string]any{"org": o.Slug})
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+func membersOf(c *fiber.Ctx, orgID int) error {
+	rows, err := db.PG.Query(c.Context(),
+		`SELECT id, username, org_role FROM users WHERE org_id=$1 ORDER BY username`, orgID)
+	if err != nil {
+		return fiber.ErrInternalServerError
+	}
+	members, err := pgx.CollectRows(rows, func(r pgx.CollectableRow) (orgMember, error) {
+		var m orgMember
+		err := r.Scan(&m.ID, &m.Username, &m.Role)
+		return m, err
+	})
+	if err != nil {
+		return fiber.ErrInternalServerError
+	}
+	return c.JSON(members)
+}
+
+func orgDetails(c *fiber.Ctx, o *org.Org) error {
+	usage, err := org.GetUsage(c.Context(), o.ID)
+	if err != nil {
+		return fiber.ErrInternalServerError
+	}
+	return c.JSON(fiber.Map{"org": o, "usage": usage})
+}
+
+// targetOrg loads the organization named by :org
+func targetOrg(c *fiber.Ctx) (*org.Org, error) {
+	o, err := org.BySlug(c.Context(), c.Params("org"))
+	if errors.Is(err, org.ErrNotFound) {
+		return nil, fiber.ErrNotFound
+	}
+	if err != nil {
+		return nil, fiber.ErrInternalServerError
+	}
+	return o, nil
+}
+
+// callerOrg loads the request user's organization
+func callerOrg(c *fiber.Ctx) (*org.Org, error) {
+	id, _ := auth.OrgOf(c)
+	if id == 0 {
+		return nil, fiber.NewError(fiber.StatusNotFound, "not a member of an organization")
+	}
+	o, err := org.Get(c.Context(), id)
+	if errors.Is(err, org.ErrNotFound) {
+		return nil, fiber.ErrNotFound
+	}
+	if err != nil {
+		return nil, fiber.ErrInternalServerError
+	}
+	return o, nil
+}
+
+// memberRole parses {"role"}, defaulting to member
+func memberRole(c *fiber.Ctx) (string, error) {
+	type req struct {
+		Role string `json:"role"`
+	}
+	var body req
+	c.BodyParser(&body)
+	switch body.Role {
+	case "":
+		return org.RoleMember, nil
+	case org.RoleMember, org.RoleAdmin:
+		return body.Role, nil
+	}
+	return "", fiber.NewError(fiber.StatusBadRequest, "role must be member or admin")
+}
+
+// positive maps an unset or non-positive quota to nil (unlimited)
+func positive[T int | int64](v *T) *T {
+	if v == nil || *v <= 0 {
+		return nil
+	}
+	return v
+}
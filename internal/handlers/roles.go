@@ -0,0 +1,289 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/zeelrupapara/custom-ai-server/pkg/auth"
+	"github.com/zeelrupapara/custom-ai-server/pkg/db"
+)
+
+type roleView struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+type groupView struct {
+	Name      string   `json:"name"`
+	OIDCGroup *string  `json:"oidc_group"`
+	Roles     []string `json:"roles"`
+	Members   []int    `json:"members"`
+}
+
+// ListRoles returns every role
+func ListRoles(c *fiber.Ctx) error {
+	rows, err := db.PG.Query(c.Context(), `SELECT name, description FROM roles ORDER BY name`)
+	if err != nil {
+		return fiber.ErrInternalServerError
+	}
+	roles, err := pgx.CollectRows(rows, func(r pgx.CollectableRow) (roleView, error) {
+		var v roleView
+		err := r.Scan(&v.Name, &v.Description)
+		return v, err
+	})
+	if err != nil {
+		return fiber.ErrInternalServerError
+	}
+	return c.JSON(roles)
+}
+
+// CreateRole adds a role that GPT configs can list in allowed_roles
+func CreateRole(c *fiber.Ctx) error {
+	var body roleView
+	if err := c.BodyParser(&body); err != nil || strings.TrimSpace(body.Name) == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "name is required")
+	}
+	_, err := db.PG.Exec(c.Context(), `INSERT INTO roles(name,description) VALUES($1,$2)`,
+		strings.TrimSpace(body.Name), body.Description)
+	if err != nil {
+		return dbError(err)
+	}
+	auditLog(c, "role.create", "role", strings.TrimSpace(body.Name), nil)
+	return c.SendStatus(fiber.StatusCreated)
+}
+
+// DeleteRole removes a role from everyone holding it
+func DeleteRole(c *fiber.Ctx) error {
+	if err := execFound(c, `DELETE FROM roles WHERE name=$1`, c.Params("role")); err != nil {
+		return err
+	}
+	auditLog(c, "role.delete", "role", c.Params("role"), nil)
+	return nil
+}
+
+// ListGroups returns every group with its roles and member user IDs
+func ListGroups(c *fiber.Ctx) error {
+	rows, err := db.PG.Query(c.Context(),
+		`SELECT g.name, g.oidc_group,
+		   ARRAY(SELECT r.name FROM group_roles gr JOIN roles r ON r.id = gr.role_id
+		         WHERE gr.group_id = g.id ORDER BY r.name),
+		   ARRAY(SELECT gm.user_id FROM group_members gm WHERE gm.group_id = g.id ORDER BY gm.user_id)
+		 FROM groups g ORDER BY g.name`)
+	if err != nil {
+		return fiber.ErrInternalServerError
+	}
+	groups, err := pgx.CollectRows(rows, func(r pgx.CollectableRow) (groupView, error) {
+		var v groupView
+		err := r.Scan(&v.Name, &v.OIDCGroup, &v.Roles, &v.Members)
+		return v, err
+	})
+	if err != nil {
+		return fiber.ErrInternalServerError
+	}
+	return c.JSON(groups)
+}
+
+// CreateGroup adds a group. With oidc_group set, SSO logins keep its
+// membership in sync with that identity provider group.
+func CreateGroup(c *fiber.Ctx) error {
+	var body groupView
+	if err := c.BodyParser(&body); err != nil || strings.TrimSpace(body.Name) == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "name is required")
+	}
+	if body.OIDCGroup != nil && *body.OIDCGroup == "" {
+		body.OIDCGroup = nil
+	}
+	ctx := c.Context()
+	tx, err := db.PG.Begin(ctx)
+	if err != nil {
+		return fiber.ErrInternalServerError
+	}
+	defer tx.Rollback(ctx)
+	var id int
+	err = tx.QueryRow(ctx, `INSERT INTO groups(name,oidc_group) VALUES($1,$2) RETURNING id`,
+		strings.TrimSpace(body.Name), body.OIDCGroup).Scan(&id)
+	if err != nil {
+		return dbError(err)
+	}
+	for _, role := range body.Roles {
+		tag, err := tx.Exec(ctx,
+			`INSERT INTO group_roles(group_id,role_id) SELECT $1, id FROM roles WHERE name=$2`, id, role)
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+		if tag.RowsAffected() == 0 {
+			return fiber.NewError(fiber.StatusBadRequest, "unknown role "+role)
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fiber.ErrInternalServerError
+	}
+	auditLog(c, "group.create", "group", strings.TrimSpace(body.Name), map[string]any{"oidc_group": body.OIDCGroup, "roles": body.Roles})
+	return c.SendStatus(fiber.StatusCreated)
+}
+
+// DeleteGroup removes a group; its members lose the roles it granted
+func DeleteGroup(c *fiber.Ctx) error {
+	if err := execFound(c, `DELETE FROM groups WHERE name=$1`, c.Params("group")); err != nil {
+		return err
+	}
+	auditLog(c, "group.delete", "group", c.Params("group"), nil)
+	return nil
+}
+
+// GrantGroupRole gives every member of a group a role
+func GrantGroupRole(c *fiber.Ctx) error {
+	groupID, roleID, err := groupAndRole(c.Context(), c.Params("group"), c.Params("role"))
+	if err != nil {
+		return err
+	}
+	if err := execDone(c, `INSERT INTO group_roles(group_id,role_id) VALUES($1,$2) ON CONFLICT DO NOTHING`, groupID, roleID); err != nil {
+		return err
+	}
+	auditLog(c, "group.grant_role", "group", c.Params("group"), map[string]any{"role": c.Params("role")})
+	return nil
+}
+
+// RevokeGroupRole takes a role back from a group
+func RevokeGroupRole(c *fiber.Ctx) error {
+	err := execFound(c,
+		`DELETE FROM group_roles WHERE group_id = (SELECT id FROM groups WHERE name=$1)
+		 AND role_id = (SELECT id FROM roles WHERE name=$2)`, c.Params("group"), c.Params("role"))
+	if err != nil {
+		return err
+	}
+	auditLog(c, "group.revoke_role", "group", c.Params("group"), map[string]any{"role": c.Params("role")})
+	return nil
+}
+
+// AddGroupMember adds a user to a group
+func AddGroupMember(c *fiber.Ctx) error {
+	userID, err := c.ParamsInt("id")
+	if err != nil {
+		return fiber.ErrBadRequest
+	}
+	var groupID int
+	if err := db.PG.QueryRow(c.Context(), `SELECT id FROM groups WHERE name=$1`, c.Params("group")).Scan(&groupID); err != nil {
+		return dbError(err)
+	}
+	// an unknown user trips the foreign key and becomes a 404
+	if err := execDone(c, `INSERT INTO group_members(group_id,user_id) VALUES($1,$2) ON CONFLICT DO NOTHING`, groupID, userID); err != nil {
+		return err
+	}
+	auditLog(c, "group.add_member", "user", strconv.Itoa(userID), map[string]any{"group": c.Params("group")})
+	return nil
+}
+
+// RemoveGroupMember removes a user from a group
+func RemoveGroupMember(c *fiber.Ctx) error {
+	userID, err := c.ParamsInt("id")
+	if err != nil {
+		return fiber.ErrBadRequest
+	}
+	err = execFound(c,
+		`DELETE FROM group_members WHERE group_id = (SELECT id FROM groups WHERE name=$1) AND user_id=$2`,
+		c.Params("group"), userID)
+	if err != nil {
+		return err
+	}
+	auditLog(c, "group.remove_member", "user", strconv.Itoa(userID), map[string]any{"group": c.Params("group")})
+	return nil
+}
+
+// GrantUserRole gives a user a role directly
+func GrantUserRole(c *fiber.Ctx) error {
+	userID, err := c.ParamsInt("id")
+	if err != nil {
+		return fiber.ErrBadRequest
+	}
+	var roleID int
+	err = db.PG.QueryRow(c.Context(), `SELECT id FROM roles WHERE name=$1`, c.Params("role")).Scan(&roleID)
+	if err != nil {
+		return dbError(err)
+	}
+	if err := execDone(c, `INSERT INTO user_roles(user_id,role_id) VALUES($1,$2) ON CONFLICT DO NOTHING`, userID, roleID); err != nil {
+		return err
+	}
+	auditLog(c, "user.grant_role", "user", strconv.Itoa(userID), map[string]any{"role": c.Params("role")})
+	return nil
+}
+
+// RevokeUserRole takes a directly granted role back from a user
+func RevokeUserRole(c *fiber.Ctx) error {
+	userID, err := c.ParamsInt("id")
+	if err != nil {
+		return fiber.ErrBadRequest
+	}
+	err = execFound(c,
+		`DELETE FROM user_roles WHERE user_id=$1 AND role_id = (SELECT id FROM roles WHERE name=$2)`,
+		userID, c.Params("role"))
+	if err != nil {
+		return err
+	}
+	auditLog(c, "user.revoke_role", "user", strconv.Itoa(userID), map[string]any{"role": c.Params("role")})
+	return nil
+}
+
+// UserRoles returns a user's effective roles, direct and through groups
+func UserRoles(c *fiber.Ctx) error {
+	userID, err := c.ParamsInt("id")
+	if err != nil {
+		return fiber.ErrBadRequest
+	}
+	roles, err := auth.Roles(c.Context(), userID)
+	if err != nil {
+		return fiber.ErrInternalServerError
+	}
+	return c.JSON(fiber.Map{"user_id": userID, "roles": roles})
+}
+
+// groupAndRole resolves a group and a role by name
+func groupAndRole(ctx context.Context, group, role string) (groupID, roleID int, err error) {
+	if err := db.PG.QueryRow(ctx, `SELECT id FROM groups WHERE name=$1`, group).Scan(&groupID); err != nil {
+		return 0, 0, dbError(err)
+	}
+	if err := db.PG.QueryRow(ctx, `SELECT id FROM roles WHERE name=$1`, role).Scan(&roleID); err != nil {
+		return 0, 0, dbError(err)
+	}
+	return groupID, roleID, nil
+}
+
+// execDone runs an idempotent statement and answers 204
+func execDone(c *fiber.Ctx, sql string, args ...any) error {
+	if _, err := db.PG.Exec(c.Context(), sql, args...); err != nil {
+		return dbError(err)
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// execFound runs a statement and answers 404 when it touched no rows
+func execFound(c *fiber.Ctx, sql string, args ...any) error {
+	tag, err := db.PG.Exec(c.Context(), sql, args...)
+	if err != nil {
+		return dbError(err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fiber.ErrNotFound
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// dbError maps missing rows to 404, constraint violations to 409/404 and
+// anything else to 500
+func dbError(err error) error {
+	var pgErr *pgconn.PgError
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		return fiber.ErrNotFound
+	case errors.As(err, &pgErr) && pgErr.Code == "23505": // unique_violation
+		return fiber.ErrConflict
+	case errors.As(err, &pgErr) && pgErr.Code == "23503": // foreign_key_violation
+		return fiber.ErrNotFound
+	}
+	return fiber.ErrInternalServerError
+}
@@ -3,13 +3,17 @@ package handlers
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/ledongthuc/pdf"
+	"github.com/zeelrupapara/custom-ai-server/pkg/auth"
 	"github.com/zeelrupapara/custom-ai-server/pkg/db"
+	"github.com/zeelrupapara/custom-ai-server/pkg/org"
 )
 
 // UploadFile handles PDF/TXT uploads; extracts text and stores in Redis
@@ -19,10 +23,35 @@ func UploadFile(c *fiber.Ctx) error {
 	if err != nil {
 		return fiber.ErrBadRequest
 	}
-	dst := filepath.Join("uploads", file.Filename)
+	orgID, _ := auth.OrgOf(c)
+	if err := org.CheckUpload(c.Context(), orgID, file.Size); err != nil {
+		if errors.Is(err, org.ErrQuotaExceeded) {
+			return fiber.NewError(fiber.StatusRequestEntityTooLarge, "organization upload quota exceeded")
+		}
+		return fiber.ErrInternalServerError
+	}
+	// per-user directories keep users from overwriting each other's files
+	dir := filepath.Join("uploads", strconv.Itoa(userID))
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fiber.ErrInternalServerError
+	}
+	dst := filepath.Join(dir, filepath.Base(file.Filename))
 	if err := c.SaveFile(file, dst); err != nil {
 		return fiber.ErrInternalServerError
 	}
+	var uploadOrg *int
+	if orgID != 0 {
+		uploadOrg = &orgID
+	}
+	// saving under an existing name replaced that file, so replace its row too
+	_, err = db.PG.Exec(c.Context(),
+		`INSERT INTO uploads(user_id,org_id,filename,path,size) VALUES($1,$2,$3,$4,$5)
+		 ON CONFLICT (user_id,path) DO UPDATE
+		 SET org_id=EXCLUDED.org_id, filename=EXCLUDED.filename, size=EXCLUDED.size, created_at=NOW()`,
+		userID, uploadOrg, file.Filename, dst, file.Size)
+	if err != nil {
+		return fiber.ErrInternalServerError
+	}
 	f, err := os.Open(dst)
 	if err != nil {
 		return fiber.ErrInternalServerError
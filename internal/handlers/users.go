@@ -0,0 +1,308 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5"
+	"go.uber.org/zap"
+
+	"github.com/zeelrupapara/custom-ai-server/pkg/audit"
+	"github.com/zeelrupapara/custom-ai-server/pkg/auth"
+	"github.com/zeelrupapara/custom-ai-server/pkg/db"
+)
+
+type userView struct {
+	ID            int        `json:"id"`
+	Username      string     `json:"username"`
+	Email         *string    `json:"email"`
+	EmailVerified bool       `json:"email_verified"`
+	IsAdmin       bool       `json:"is_admin"`
+	DisabledAt    *time.Time `json:"disabled_at"`
+	CreatedAt     time.Time  `json:"created_at"`
+	Roles         []string   `json:"roles"`
+}
+
+// userColumns selects a userView from users u, with effective roles
+const userColumns = `u.id, u.username, u.email, u.email_verified_at IS NOT NULL, u.is_admin, u.disabled_at, u.created_at,
+	ARRAY(SELECT r.name FROM roles r JOIN user_roles ur ON ur.role_id = r.id WHERE ur.user_id = u.id
+	      UNION
+	      SELECT r.name FROM roles r JOIN group_roles gr ON gr.role_id = r.id
+	      JOIN group_members gm ON gm.group_id = gr.group_id WHERE gm.user_id = u.id
+	      ORDER BY 1)`
+
+func scanUser(r pgx.Row) (userView, error) {
+	var v userView
+	err := r.Scan(&v.ID, &v.Username, &v.Email, &v.EmailVerified, &v.IsAdmin, &v.DisabledAt, &v.CreatedAt, &v.Roles)
+	return v, err
+}
+
+// ListUsers handles GET /admin/users. Query: q (username or email
+// substring), status (active | disabled), admin (true | false), limit, offset.
+func ListUsers(c *fiber.Ctx) error {
+	var (
+		where []string
+		args  []any
+	)
+	add := func(cond string, v any) {
+		args = append(args, v)
+		where = append(where, fmt.Sprintf(cond, len(args)))
+	}
+	if q := strings.TrimSpace(c.Query("q")); q != "" {
+		add("(u.username ILIKE $%[1]d OR u.email ILIKE $%[1]d)", "%"+q+"%")
+	}
+	switch c.Query("status") {
+	case "active":
+		where = append(where, "u.disabled_at IS NULL")
+	case "disabled":
+		where = append(where, "u.disabled_at IS NOT NULL")
+	case "":
+	default:
+		return fiber.NewError(fiber.StatusBadRequest, "status must be active or disabled")
+	}
+	if a := c.Query("admin"); a != "" {
+		isAdmin, err := strconv.ParseBool(a)
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "admin must be true or false")
+		}
+		add("u.is_admin=$%d", isAdmin)
+	}
+	filter := ""
+	if len(where) > 0 {
+		filter = " WHERE " + strings.Join(where, " AND ")
+	}
+	limit := min(max(c.QueryInt("limit", 50), 1), 200)
+	offset := max(c.QueryInt("offset", 0), 0)
+
+	ctx := c.Context()
+	var total int
+	if err := db.PG.QueryRow(ctx, `SELECT COUNT(*) FROM users u`+filter, args...).Scan(&total); err != nil {
+		return fiber.ErrInternalServerError
+	}
+	rows, err := db.PG.Query(ctx,
+		fmt.Sprintf(`SELECT %s FROM users u%s ORDER BY u.id LIMIT %d OFFSET %d`, userColumns, filter, limit, offset),
+		args...)
+	if err != nil {
+		return fiber.ErrInternalServerError
+	}
+	users, err := pgx.CollectRows(rows, func(r pgx.CollectableRow) (userView, error) { return scanUser(r) })
+	if err != nil {
+		return fiber.ErrInternalServerError
+	}
+	return c.JSON(fiber.Map{"users": users, "total": total})
+}
+
+// GetUser handles GET /admin/users/:id
+func GetUser(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return fiber.ErrBadRequest
+	}
+	v, err := scanUser(db.PG.QueryRow(c.Context(), `SELECT `+userColumns+` FROM users u WHERE u.id=$1`, id))
+	if err != nil {
+		return dbError(err)
+	}
+	return c.JSON(v)
+}
+
+// UpdateUser handles PATCH /admin/users/:id {"is_admin": bool}. Changing
+// is_admin revokes the user's sessions so old tokens lose the old claim.
+func UpdateUser(c *fiber.Ctx) error {
+	id, err := targetUser(c)
+	if err != nil {
+		return err
+	}
+	type req struct {
+		IsAdmin *bool `json:"is_admin"`
+	}
+	var body req
+	if err := c.BodyParser(&body); err != nil || body.IsAdmin == nil {
+		return fiber.NewError(fiber.StatusBadRequest, "is_admin is required")
+	}
+	if id == c.Locals("userID").(int) && !*body.IsAdmin {
+		return fiber.NewError(fiber.StatusConflict, "admins can't demote themselves")
+	}
+	tag, err := db.PG.Exec(c.Context(),
+		`UPDATE users SET is_admin=$1, token_version=token_version+1 WHERE id=$2 AND is_admin<>$1`, *body.IsAdmin, id)
+	if err != nil {
+		return fiber.ErrInternalServerError
+	}
+	if tag.RowsAffected() > 0 {
+		CloseUserSockets(id, "role changed")
+		auditLog(c, "user.set_admin", "user", strconv.Itoa(id), map[string]any{"is_admin": *body.IsAdmin})
+	}
+	return GetUser(c)
+}
+
+// DisableUser handles POST /admin/users/:id/disable. The user's tokens,
+// API keys and open WebSockets stop working immediately.
+func DisableUser(c *fiber.Ctx) error {
+	id, err := targetUser(c)
+	if err != nil {
+		return err
+	}
+	if id == c.Locals("userID").(int) {
+		return fiber.NewError(fiber.StatusConflict, "admins can't disable themselves")
+	}
+	tag, err := db.PG.Exec(c.Context(),
+		`UPDATE users SET disabled_at=NOW(), token_version=token_version+1 WHERE id=$1 AND disabled_at IS NULL`, id)
+	if err != nil {
+		return fiber.ErrInternalServerError
+	}
+	if tag.RowsAffected() > 0 {
+		CloseUserSockets(id, "account disabled")
+		auditLog(c, "user.disable", "user", strconv.Itoa(id), nil)
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// EnableUser handles POST /admin/users/:id/enable
+func EnableUser(c *fiber.Ctx) error {
+	id, err := targetUser(c)
+	if err != nil {
+		return err
+	}
+	tag, err := db.PG.Exec(c.Context(), `UPDATE users SET disabled_at=NULL WHERE id=$1 AND disabled_at IS NOT NULL`, id)
+	if err != nil {
+		return fiber.ErrInternalServerError
+	}
+	if tag.RowsAffected() > 0 {
+		auditLog(c, "user.enable", "user", strconv.Itoa(id), nil)
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// ResetUserPassword handles POST /admin/users/:id/reset-password. With
+// {"password"} it sets that password; with an empty body it mails the user
+// a reset link at their verified address.
+func ResetUserPassword(c *fiber.Ctx) error {
+	id, err := targetUser(c)
+	if err != nil {
+		return err
+	}
+	type req struct {
+		Password string `json:"password"`
+	}
+	var body req
+	c.BodyParser(&body)
+	ctx := c.Context()
+	if body.Password == "" {
+		if err := auth.MailPasswordReset(ctx, id); err != nil {
+			var ferr *fiber.Error
+			if errors.As(err, &ferr) {
+				return ferr
+			}
+			zap.L().Error("password reset email failed", zap.Int("user_id", id), zap.Error(err))
+			return fiber.NewError(fiber.StatusBadGateway, "could not send email")
+		}
+		auditLog(c, "user.send_password_reset", "user", strconv.Itoa(id), nil)
+		return c.SendStatus(fiber.StatusAccepted)
+	}
+	if err := auth.SetPassword(ctx, id, body.Password); err != nil {
+		var ferr *fiber.Error
+		if errors.As(err, &ferr) {
+			return ferr
+		}
+		return fiber.ErrInternalServerError
+	}
+	CloseUserSockets(id, "password changed")
+	auditLog(c, "user.set_password", "user", strconv.Itoa(id), nil)
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// DeleteUser handles DELETE /admin/users/:id. Chat history, conversations,
+// API keys and upload records go with the user; uploaded files and cached
+// file context are removed as well.
+func DeleteUser(c *fiber.Ctx) error {
+	id, err := targetUser(c)
+	if err != nil {
+		return err
+	}
+	if id == c.Locals("userID").(int) {
+		return fiber.NewError(fiber.StatusConflict, "admins can't delete themselves")
+	}
+	ctx := c.Context()
+	tx, err := db.PG.Begin(ctx)
+	if err != nil {
+		return fiber.ErrInternalServerError
+	}
+	defer tx.Rollback(ctx)
+	rows, err := tx.Query(ctx, `SELECT path FROM uploads WHERE user_id=$1`, id)
+	if err != nil {
+		return fiber.ErrInternalServerError
+	}
+	paths, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return fiber.ErrInternalServerError
+	}
+	var username string
+	if err := tx.QueryRow(ctx, `DELETE FROM users WHERE id=$1 RETURNING username`, id).Scan(&username); err != nil {
+		return dbError(err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fiber.ErrInternalServerError
+	}
+
+	CloseUserSockets(id, "account deleted")
+	for _, p := range paths {
+		if err := os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+			zap.L().Warn("removing upload failed", zap.String("path", p), zap.Error(err))
+		}
+	}
+	os.Remove(filepath.Join("uploads", strconv.Itoa(id)))
+	db.RDB.Del(ctx, fmt.Sprintf("filectx:%d", id))
+	auditLog(c, "user.delete", "user", strconv.Itoa(id), map[string]any{"username": username, "uploads": len(paths)})
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// ListAudit handles GET /admin/audit. Query: actor, action, target_type,
+// target_id, before (entry id, for paging) and limit.
+func ListAudit(c *fiber.Ctx) error {
+	entries, err := audit.List(c.Context(), audit.Filter{
+		ActorID:    c.QueryInt("actor"),
+		Action:     c.Query("action"),
+		TargetType: c.Query("target_type"),
+		TargetID:   c.Query("target_id"),
+		BeforeID:   int64(c.QueryInt("before")),
+		Limit:      c.QueryInt("limit"),
+	})
+	if err != nil {
+		return fiber.ErrInternalServerError
+	}
+	return c.JSON(entries)
+}
+
+// targetUser parses :id and checks the user exists
+func targetUser(c *fiber.Ctx) (int, error) {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return 0, fiber.ErrBadRequest
+	}
+	var exists bool
+	if err := db.PG.QueryRow(c.Context(), `SELECT EXISTS(SELECT 1 FROM users WHERE id=$1)`, id).Scan(&exists); err != nil {
+		return 0, fiber.ErrInternalServerError
+	}
+	if !exists {
+		return 0, fiber.ErrNotFound
+	}
+	return id, nil
+}
+
+// auditLog records an admin action taken by the request's user
+func auditLog(c *fiber.Ctx, action, targetType, targetID string, details map[string]any) {
+	actor := c.Locals("userID").(int)
+	audit.Record(c.Context(), audit.Entry{
+		ActorID:    &actor,
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetID,
+		Details:    details,
+		IP:         c.IP(),
+	})
+}
@@ -2,17 +2,53 @@ package handlers
 
 import (
 	"context"
-	"fmt"
+	"encoding/json"
+	"errors"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/websocket/v2"
-	"github.com/zeelrupapara/custom-ai-server/pkg/ai"
-	"github.com/zeelrupapara/custom-ai-server/pkg/gpt"
+	"github.com/zeelrupapara/custom-ai-server/pkg/auth"
+	"github.com/zeelrupapara/custom-ai-server/pkg/config"
+	"github.com/zeelrupapara/custom-ai-server/pkg/dispatcher"
+	"github.com/zeelrupapara/custom-ai-server/pkg/i18n"
+	"go.uber.org/zap"
 )
 
-// WSUpgrade rejects non‑WebSocket requests
+// wsFrame is the JSON envelope for every server → client message.
+type wsFrame struct {
+	Type           string `json:"type"` // ready | queued | reply | cancelled | authenticated | shutdown | error
+	ID             string `json:"id,omitempty"`
+	ConversationID string `json:"conversation_id,omitempty"`
+	Content        string `json:"content,omitempty"`
+	Position       int    `json:"position,omitempty"`
+	Code           string `json:"code,omitempty"`
+	Retryable      bool   `json:"retryable,omitempty"`
+	// Detail is the underlying error, when it says more than Content
+	Detail string `json:"detail,omitempty"`
+	// Data is a reply parsed as JSON, for GPTs with a response_format
+	Data json.RawMessage `json:"data,omitempty"`
+	// GPT describes the GPT in the ready frame, as GET /gpts does
+	GPT *gptInfo `json:"gpt,omitempty"`
+}
+
+// wsInbound is a client → server message. Frames that are not JSON
+// are treated as {"type":"message","content":<frame>}.
+type wsInbound struct {
+	Type    string `json:"type"` // message | cancel | auth
+	ID      string `json:"id"`
+	Content string `json:"content"`
+}
+
+// WSUpgrade rejects non‑WebSocket requests and callers who may not use the
+// GPT (API key scope, or a restricted GPT without an allowed role)
 func WSUpgrade(c *fiber.Ctx) error {
+	// unknown slugs get an error frame from HandleWS
+	if cfg, err := dispatcher.Lookup(c.Params("slug")); err == nil && !auth.CanUseGPT(c, cfg) {
+		return fiber.ErrForbidden
+	}
 	if websocket.IsWebSocketUpgrade(c) {
+		c.Locals("locale", requestLocale(c))
 		// allow next() to call the actual websocket handler
 		return c.Next()
 	}
@@ -21,29 +57,111 @@ func WSUpgrade(c *fiber.Ctx) error {
 
 // HandleWS is the WebSocket entrypoint
 func HandleWS(c *websocket.Conn) {
-	slug := c.Params("slug")
-	cfg, ok := gpt.Configs[slug]
-	if !ok {
-		c.WriteMessage(websocket.TextMessage, []byte("unknown GPT"))
+	locale, _ := c.Locals("locale").(string)
+	cfg, err := dispatcher.Lookup(c.Params("slug"))
+	if err != nil {
+		c.WriteJSON(errorFrame(err, locale))
 		return
 	}
-	model, err := ai.NewAI(context.Background(), cfg.Model, cfg.SystemPrompt, cfg.Name, cfg.Files)
-	if err != nil {
-		c.WriteMessage(websocket.TextMessage, []byte("AI error: "+err.Error()))
+	if _, err := dispatcher.Prepare(context.Background(), cfg); err != nil {
+		c.WriteJSON(errorFrame(err, locale))
 		return
 	}
-	c.WriteMessage(websocket.TextMessage, []byte("Your assistant is ready, ask anything to "+cfg.Name))
+
+	userID, _ := c.Locals("userID").(int)
+	orgID, _ := c.Locals("orgID").(int)
+	tokenExp, _ := c.Locals("tokenExp").(time.Time)
+
+	appCfg := config.Load()
+	// a missed pong lets the read deadline lapse and ReadMessage fail;
+	// without pings there is no deadline
+	pongWait := 2 * appCfg.WSPingInterval
+	extend := func() error {
+		if pongWait <= 0 {
+			return nil
+		}
+		return c.SetReadDeadline(time.Now().Add(pongWait))
+	}
+	c.SetReadLimit(int64(appCfg.WSMaxMessageBytes))
+	extend()
+	c.SetPongHandler(func(string) error { return extend() })
+
+	s := newWSSession(c, dispatcher.Caller{UserID: userID, OrgID: orgID, Locale: locale}, cfg.Slug, appCfg.WSConcurrencyPolicy, appCfg.WSQueueSize)
+	// resume an earlier conversation, e.g. after a reconnect
+	s.conversationID = c.Query("conversation_id")
+	register(s)
+	defer unregister(s)
+	defer s.close()
+	go s.heartbeat(appCfg.WSPingInterval, appCfg.WSIdleTimeout, tokenExp)
+
+	info := infoGPT(cfg, locale)
+	s.send(wsFrame{Type: "ready", Content: info.Welcome, GPT: info})
 	for {
 		_, msg, err := c.ReadMessage()
 		if err != nil {
 			break
 		}
-		fmt.Println("Received message:", string(msg))
-		stream, err := model.Chat(context.Background(), string(msg))
-		if err != nil {
-			c.WriteMessage(websocket.TextMessage, []byte("AI error: "+err.Error()))
-			continue
+		extend()
+		in := parseInbound(msg)
+		switch in.Type {
+		case "cancel":
+			s.cancel(in.ID)
+		case "auth":
+			s.reauthenticate(in)
+		case "message":
+			zap.L().Debug("ws message", zap.String("slug", cfg.Slug), zap.Int("user_id", userID), zap.Int("bytes", len(in.Content)))
+			err := dispatcher.Admit(s.ctx, userID, orgID, cfg)
+			switch {
+			case errors.Is(err, dispatcher.ErrRateLimitAbuse):
+				// still sending well past the limit: drop the connection
+				s.closeWith(CloseRateLimited, "rate limited")
+				return
+			case err != nil:
+				f := errorFrame(err, locale)
+				f.ID = in.ID
+				s.send(f)
+				continue
+			}
+			s.enqueue(in.ID, in.Content)
+		default:
+			s.send(wsFrame{Type: "error", ID: in.ID, Code: "invalid_request", Content: i18n.T(locale, "unknown_message_type", in.Type)})
 		}
-		c.WriteMessage(websocket.TextMessage, []byte(stream))
 	}
 }
+
+// reauthenticate accepts a fresh access token for the connection's user so
+// a long-lived socket outlives the token it was opened with.
+func (s *wsSession) reauthenticate(in wsInbound) {
+	if _, ok := s.conn.Locals("tokenExp").(time.Time); !ok {
+		s.send(wsFrame{Type: "error", ID: in.ID, Code: "invalid_request", Content: i18n.T(s.caller.Locale, "not_session_token")})
+		return
+	}
+	claims, err := auth.ValidateAccessToken(s.ctx, in.Content)
+	if err != nil || claims.UserID != s.caller.UserID {
+		s.send(wsFrame{Type: "error", ID: in.ID, Code: "auth", Content: i18n.T(s.caller.Locale, "invalid_token")})
+		return
+	}
+	select {
+	case <-s.reauth: // replace an expiry the heartbeat has not picked up yet
+	default:
+	}
+	s.reauth <- claims.Expires
+	s.send(wsFrame{Type: "authenticated", ID: in.ID})
+}
+
+// parseInbound decodes a client frame, falling back to plain text
+func parseInbound(msg []byte) wsInbound {
+	var in wsInbound
+	if err := json.Unmarshal(msg, &in); err != nil || in.Type == "" {
+		return wsInbound{Type: "message", Content: string(msg)}
+	}
+	return in
+}
+
+// errorFrame maps err to an error frame in locale; see errorCode for the
+// codes
+func errorFrame(err error, locale string) wsFrame {
+	code, _, retryable := errorCode(err)
+	msg, detail := errorText(err, code, locale)
+	return wsFrame{Type: "error", Code: code, Content: msg, Detail: detail, Retryable: retryable}
+}
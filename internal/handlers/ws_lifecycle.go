@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/gofiber/websocket/v2"
+)
+
+// Application close codes (4000–4999) sent alongside the standard ones
+const (
+	CloseIdleTimeout = 4000
+	CloseAuthExpired = 4001
+	CloseRateLimited = 4029
+)
+
+// closeWriteWait bounds how long a close/ping control frame may take to write
+const closeWriteWait = 5 * time.Second
+
+// sessions tracks live WebSocket sessions so shutdown can drain them
+var (
+	sessionsMu sync.Mutex
+	sessions   = map[*wsSession]struct{}{}
+)
+
+func register(s *wsSession) {
+	sessionsMu.Lock()
+	sessions[s] = struct{}{}
+	sessionsMu.Unlock()
+}
+
+func unregister(s *wsSession) {
+	sessionsMu.Lock()
+	delete(sessions, s)
+	sessionsMu.Unlock()
+}
+
+// DrainWebSockets stops every session from accepting messages, lets running
+// generations finish until ctx ends, then closes each socket with 1001.
+func DrainWebSockets(ctx context.Context) {
+	sessionsMu.Lock()
+	live := make([]*wsSession, 0, len(sessions))
+	for s := range sessions {
+		live = append(live, s)
+	}
+	sessionsMu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, s := range live {
+		wg.Add(1)
+		go func(s *wsSession) {
+			defer wg.Done()
+			s.drain(ctx)
+			s.closeWith(websocket.CloseGoingAway, "server shutdown")
+		}(s)
+	}
+	wg.Wait()
+}
+
+// CloseUserSockets ends every live WebSocket of userID on this instance,
+// e.g. after their account is disabled or their sessions are revoked.
+func CloseUserSockets(userID int, reason string) {
+	sessionsMu.Lock()
+	var live []*wsSession
+	for s := range sessions {
+		if s.caller.UserID == userID {
+			live = append(live, s)
+		}
+	}
+	sessionsMu.Unlock()
+	for _, s := range live {
+		s.closeWith(CloseAuthExpired, reason)
+	}
+}
+
+// closeWith sends a close frame with code and reason, then tears the
+// connection down so the read loop in HandleWS returns.
+func (s *wsSession) closeWith(code int, reason string) {
+	s.stop()
+	s.conn.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(code, reason), time.Now().Add(closeWriteWait))
+	s.conn.Close()
+}
+
+// heartbeat pings the client every interval and closes the connection when
+// it has been idle too long or the caller's token expires. Missed pongs are
+// caught by the read deadline HandleWS maintains. An auth frame moves the
+// token expiry forward through s.reauth. An interval <= 0 sends no pings.
+func (s *wsSession) heartbeat(interval, idle time.Duration, tokenExp time.Time) {
+	var tick <-chan time.Time
+	check := interval
+	if check <= 0 {
+		check = idle / 2 // no pings, but the idle timeout still applies
+	}
+	if check > 0 {
+		ticker := time.NewTicker(check)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	var (
+		timer   *time.Timer
+		expired <-chan time.Time
+	)
+	if !tokenExp.IsZero() {
+		timer = time.NewTimer(time.Until(tokenExp))
+		defer timer.Stop()
+		expired = timer.C
+	}
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case exp := <-s.reauth:
+			if timer != nil {
+				// drain a fire we haven't received, or it would close the
+				// socket right after the reset
+				if !timer.Stop() {
+					select {
+					case <-timer.C:
+					default:
+					}
+				}
+				timer.Reset(time.Until(exp))
+			}
+		case <-expired:
+			s.closeWith(CloseAuthExpired, "auth expired")
+			return
+		case <-tick:
+			if idle > 0 && s.idleFor() > idle {
+				s.closeWith(CloseIdleTimeout, "idle timeout")
+				return
+			}
+			if interval <= 0 {
+				continue
+			}
+			if err := s.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(closeWriteWait)); err != nil {
+				s.conn.Close()
+				return
+			}
+		}
+	}
+}
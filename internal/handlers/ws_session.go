@@ -0,0 +1,300 @@
+package handlers
+
+import (
+	"context"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gofiber/websocket/v2"
+	"github.com/zeelrupapara/custom-ai-server/pkg/ai"
+	"github.com/zeelrupapara/custom-ai-server/pkg/dispatcher"
+	"github.com/zeelrupapara/custom-ai-server/pkg/i18n"
+)
+
+// Concurrency policies for a message that arrives while another is generating
+const (
+	PolicyReject    = "reject"    // answer with a busy error
+	PolicyQueue     = "queue"     // wait behind the running message
+	PolicyInterrupt = "interrupt" // cancel everything pending and run the new one
+)
+
+// wsJob is one user prompt waiting for, or undergoing, generation
+type wsJob struct {
+	id     string
+	prompt string
+}
+
+// wsSession owns one connection: a worker runs generations one at a time
+// while the read loop stays free to enqueue, cancel and interrupt.
+type wsSession struct {
+	conn      *websocket.Conn
+	caller    dispatcher.Caller
+	slug      string
+	policy    string
+	queueSize int
+
+	// conversationID is only touched by the worker once it has started
+	conversationID string
+
+	// ctx ends when the socket closes, cancelling any in-flight run
+	ctx  context.Context
+	stop context.CancelFunc
+	wake chan struct{}
+	done chan struct{}
+	// reauth carries the expiry of a token presented in an auth frame
+	reauth chan time.Time
+
+	write   func(v any) error // conn.WriteJSON
+	writeMu sync.Mutex        // websocket.Conn allows a single concurrent writer
+
+	mu         sync.Mutex
+	seq        int
+	queue      []wsJob
+	running    *wsJob
+	cancelRun  context.CancelFunc // cancels the running job; nil when idle
+	lastActive time.Time
+	draining   bool
+}
+
+func newWSSession(c *websocket.Conn, caller dispatcher.Caller, slug, policy string, queueSize int) *wsSession {
+	switch policy {
+	case PolicyReject, PolicyQueue, PolicyInterrupt:
+	default:
+		policy = PolicyQueue
+	}
+	if queueSize < 1 {
+		queueSize = 1
+	}
+	ctx, stop := context.WithCancel(context.Background())
+	s := &wsSession{
+		conn:       c,
+		write:      c.WriteJSON,
+		caller:     caller.Remembered(),
+		slug:       slug,
+		policy:     policy,
+		queueSize:  queueSize,
+		ctx:        ctx,
+		stop:       stop,
+		wake:       make(chan struct{}, 1),
+		done:       make(chan struct{}),
+		reauth:     make(chan time.Time, 1),
+		lastActive: time.Now(),
+	}
+	go s.worker()
+	return s
+}
+
+// send serializes writes to the connection
+func (s *wsSession) send(f wsFrame) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	return s.write(f)
+}
+
+// sendAll writes frames collected while s.mu was held
+func (s *wsSession) sendAll(frames []wsFrame) {
+	for _, f := range frames {
+		s.send(f)
+	}
+}
+
+// enqueue applies the concurrency policy to a new prompt
+func (s *wsSession) enqueue(id, prompt string) {
+	s.mu.Lock()
+	if id == "" {
+		// prefixed so they never collide with ids the client picks
+		s.seq++
+		id = "srv-" + strconv.Itoa(s.seq)
+	} else if s.inUseLocked(id) {
+		s.mu.Unlock()
+		s.send(wsFrame{Type: "error", ID: id, Code: "invalid_request", Content: i18n.T(s.caller.Locale, "duplicate_id", id)})
+		return
+	}
+	s.lastActive = time.Now()
+	busy := s.running != nil || len(s.queue) > 0
+	var out []wsFrame
+	switch {
+	case s.draining:
+		s.mu.Unlock()
+		s.send(wsFrame{Type: "error", ID: id, Code: "shutting_down", Content: i18n.T(s.caller.Locale, "shutting_down"), Retryable: true})
+		return
+	case busy && s.policy == PolicyReject:
+		s.mu.Unlock()
+		s.send(wsFrame{Type: "error", ID: id, Code: "busy", Content: i18n.T(s.caller.Locale, "busy"), Retryable: true})
+		return
+	case busy && s.policy == PolicyInterrupt:
+		if s.cancelRun != nil {
+			s.cancelRun() // the worker reports the running job as cancelled
+		}
+		for _, j := range s.queue {
+			out = append(out, wsFrame{Type: "cancelled", ID: j.id})
+		}
+		s.queue = nil
+	case len(s.queue) >= s.queueSize:
+		s.mu.Unlock()
+		s.send(wsFrame{Type: "error", ID: id, Code: "busy", Content: i18n.T(s.caller.Locale, "queue_full"), Retryable: true})
+		return
+	}
+	s.queue = append(s.queue, wsJob{id: id, prompt: prompt})
+	if s.running != nil || len(s.queue) > 1 {
+		out = append(out, wsFrame{Type: "queued", ID: id, Position: len(s.queue)})
+	}
+	s.mu.Unlock()
+
+	s.sendAll(out)
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// inUseLocked reports whether id names the running or a queued job, so
+// cancel would be ambiguous
+func (s *wsSession) inUseLocked(id string) bool {
+	if s.running != nil && s.running.id == id {
+		return true
+	}
+	return slices.ContainsFunc(s.queue, func(j wsJob) bool { return j.id == id })
+}
+
+// cancel stops the job with the given id, or the running job when id is empty
+func (s *wsSession) cancel(id string) {
+	s.mu.Lock()
+	if s.running != nil && (id == "" || s.running.id == id) {
+		s.cancelRun()
+		s.mu.Unlock()
+		return
+	}
+	var out []wsFrame
+	for i, j := range s.queue {
+		if j.id == id {
+			s.queue = append(s.queue[:i], s.queue[i+1:]...)
+			out = append(out, wsFrame{Type: "cancelled", ID: id})
+			out = append(out, s.positionsLocked()...)
+			break
+		}
+	}
+	s.mu.Unlock()
+	s.sendAll(out)
+}
+
+// positionsLocked reports the current queue position of every waiting job
+func (s *wsSession) positionsLocked() []wsFrame {
+	out := make([]wsFrame, 0, len(s.queue))
+	for i, j := range s.queue {
+		out = append(out, wsFrame{Type: "queued", ID: j.id, Position: i + 1})
+	}
+	return out
+}
+
+func (s *wsSession) worker() {
+	defer close(s.done)
+	for {
+		job, ctx, ok := s.next()
+		if !ok {
+			return
+		}
+		s.generate(ctx, job)
+	}
+}
+
+// next blocks until a job is queued, marks it running and returns its
+// context; ok is false once the session has ended.
+func (s *wsSession) next() (job wsJob, ctx context.Context, ok bool) {
+	for {
+		s.mu.Lock()
+		if s.ctx.Err() != nil || (s.draining && len(s.queue) == 0) {
+			s.mu.Unlock()
+			return wsJob{}, nil, false
+		}
+		if len(s.queue) > 0 {
+			job = s.queue[0]
+			s.queue = s.queue[1:]
+			ctx, s.cancelRun = context.WithCancel(s.ctx)
+			s.running = &job
+			moved := s.positionsLocked()
+			s.mu.Unlock()
+			s.sendAll(moved)
+			return job, ctx, true
+		}
+		s.mu.Unlock()
+		select {
+		case <-s.wake:
+		case <-s.ctx.Done():
+		}
+	}
+}
+
+func (s *wsSession) generate(ctx context.Context, job wsJob) {
+	reply, err := dispatcher.Send(ctx, dispatcher.Request{
+		Caller:         s.caller,
+		Slug:           s.slug,
+		ConversationID: s.conversationID,
+		Message:        job.prompt,
+	})
+	if reply != nil {
+		s.conversationID = reply.ConversationID
+	}
+
+	s.mu.Lock()
+	s.cancelRun()
+	s.cancelRun = nil
+	s.running = nil
+	s.lastActive = time.Now()
+	s.mu.Unlock()
+
+	switch {
+	case ai.KindOf(err) == ai.KindCancelled:
+		s.send(wsFrame{Type: "cancelled", ID: job.id, ConversationID: s.conversationID})
+	case err != nil:
+		f := errorFrame(err, s.caller.Locale)
+		f.ID = job.id
+		f.ConversationID = s.conversationID
+		s.send(f)
+	default:
+		s.send(wsFrame{Type: "reply", ID: job.id, ConversationID: reply.ConversationID, Content: reply.Content, Data: reply.Data})
+	}
+}
+
+// idleFor reports how long the session has had nothing to do
+func (s *wsSession) idleFor() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.running != nil || len(s.queue) > 0 {
+		return 0
+	}
+	return time.Since(s.lastActive)
+}
+
+// drain rejects new messages, drops queued ones and waits for the running
+// generation to finish; it is cancelled if ctx ends first.
+func (s *wsSession) drain(ctx context.Context) {
+	s.mu.Lock()
+	s.draining = true
+	out := []wsFrame{{Type: "shutdown", Content: i18n.T(s.caller.Locale, "shutting_down")}}
+	for _, j := range s.queue {
+		out = append(out, wsFrame{Type: "cancelled", ID: j.id})
+	}
+	s.queue = nil
+	s.mu.Unlock()
+	s.sendAll(out)
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+	select {
+	case <-s.done:
+	case <-ctx.Done():
+		s.stop()
+		<-s.done
+	}
+}
+
+// close cancels outstanding work and waits for the worker to exit
+func (s *wsSession) close() {
+	s.stop()
+	<-s.done
+}
@@ -0,0 +1,189 @@
+package handlers
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// frameLog records what a session sends
+type frameLog struct {
+	mu     sync.Mutex
+	frames []wsFrame
+}
+
+func (l *frameLog) write(v any) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.frames = append(l.frames, v.(wsFrame))
+	return nil
+}
+
+// take returns the frames sent so far as "type:id:position:code" and forgets them
+func (l *frameLog) take() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var out []string
+	for _, f := range l.frames {
+		s := f.Type + ":" + f.ID
+		if f.Position > 0 {
+			s += ":" + strconv.Itoa(f.Position)
+		}
+		if f.Code != "" {
+			s += ":" + f.Code
+		}
+		out = append(out, s)
+	}
+	l.frames = nil
+	return out
+}
+
+// testSession is a session without a connection or worker, so tests drive
+// the queue directly
+func testSession(policy string, queueSize int) (*wsSession, *frameLog) {
+	log := &frameLog{}
+	ctx, stop := context.WithCancel(context.Background())
+	s := &wsSession{
+		write:     log.write,
+		policy:    policy,
+		queueSize: queueSize,
+		ctx:       ctx,
+		stop:      stop,
+		wake:      make(chan struct{}, 1),
+		done:      make(chan struct{}),
+	}
+	return s, log
+}
+
+// run marks the head of the queue running, as the worker does
+func run(t *testing.T, s *wsSession) (cancelled func() bool) {
+	t.Helper()
+	_, ctx, ok := s.next()
+	if !ok {
+		t.Fatal("next: no job")
+	}
+	return func() bool { return ctx.Err() != nil }
+}
+
+func queueIDs(s *wsSession) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var ids []string
+	for _, j := range s.queue {
+		ids = append(ids, j.id)
+	}
+	return ids
+}
+
+func expectFrames(t *testing.T, log *frameLog, want ...string) {
+	t.Helper()
+	if got := log.take(); !reflect.DeepEqual(got, want) {
+		t.Errorf("frames = %q, want %q", got, want)
+	}
+}
+
+func TestEnqueueQueuePolicy(t *testing.T) {
+	s, log := testSession(PolicyQueue, 2)
+	s.enqueue("", "first")
+	expectFrames(t, log) // an idle session starts at once, no queued frame
+	run(t, s)
+	expectFrames(t, log)
+
+	s.enqueue("", "second")
+	s.enqueue("x", "third")
+	expectFrames(t, log, "queued:srv-2:1", "queued:x:2")
+	s.enqueue("", "fourth")
+	expectFrames(t, log, "error:srv-3:busy")
+	if got := queueIDs(s); !reflect.DeepEqual(got, []string{"srv-2", "x"}) {
+		t.Errorf("queue = %q", got)
+	}
+}
+
+func TestEnqueueRejectPolicy(t *testing.T) {
+	s, log := testSession(PolicyReject, 4)
+	s.enqueue("a", "first")
+	run(t, s)
+	s.enqueue("b", "second")
+	expectFrames(t, log, "error:b:busy")
+	if got := queueIDs(s); len(got) != 0 {
+		t.Errorf("queue = %q, want empty", got)
+	}
+}
+
+func TestEnqueueInterruptPolicy(t *testing.T) {
+	s, log := testSession(PolicyInterrupt, 4)
+	s.enqueue("a", "first")
+	cancelled := run(t, s)
+	// queue a job behind the running one without interrupting it
+	s.mu.Lock()
+	s.queue = append(s.queue, wsJob{id: "b"})
+	s.mu.Unlock()
+
+	s.enqueue("c", "third")
+	if !cancelled() {
+		t.Error("running job was not cancelled")
+	}
+	expectFrames(t, log, "cancelled:b", "queued:c:1")
+	if got := queueIDs(s); !reflect.DeepEqual(got, []string{"c"}) {
+		t.Errorf("queue = %q", got)
+	}
+}
+
+func TestCancel(t *testing.T) {
+	s, log := testSession(PolicyQueue, 4)
+	s.enqueue("a", "")
+	cancelled := run(t, s)
+	s.enqueue("b", "")
+	s.enqueue("c", "")
+	log.take()
+
+	s.cancel("b")
+	expectFrames(t, log, "cancelled:b", "queued:c:1")
+	if cancelled() {
+		t.Error("cancelling a queued job cancelled the running one")
+	}
+	s.cancel("")
+	if !cancelled() {
+		t.Error("cancel without an id left the running job going")
+	}
+	s.cancel("unknown")
+	expectFrames(t, log)
+}
+
+func TestEnqueueDuplicateID(t *testing.T) {
+	s, log := testSession(PolicyQueue, 4)
+	s.enqueue("", "")
+	run(t, s)
+	s.enqueue("1", "") // the client's "1" is not the server's srv-1
+	s.enqueue("b", "")
+	log.take()
+
+	s.enqueue("srv-1", "") // running
+	s.enqueue("b", "")     // queued
+	expectFrames(t, log, "error:srv-1:invalid_request", "error:b:invalid_request")
+	if got := queueIDs(s); !reflect.DeepEqual(got, []string{"1", "b"}) {
+		t.Errorf("queue = %q", got)
+	}
+}
+
+func TestNextReportsPositions(t *testing.T) {
+	s, log := testSession(PolicyQueue, 4)
+	s.enqueue("a", "")
+	s.enqueue("b", "")
+	s.enqueue("c", "")
+	log.take()
+	run(t, s)
+	expectFrames(t, log, "queued:b:1", "queued:c:2")
+}
+
+func TestEnqueueWhileDraining(t *testing.T) {
+	s, log := testSession(PolicyQueue, 4)
+	s.draining = true
+	s.enqueue("a", "")
+	expectFrames(t, log, "error:a:shutting_down")
+	if _, _, ok := s.next(); ok {
+		t.Error("next returned a job while draining an empty queue")
+	}
+}
@@ -32,15 +32,102 @@ func NewRouter(log *zap.Logger) *fiber.App {
 	// Auth
 	app.Post("/register", auth.Register)
 	app.Post("/login", auth.Login)
+	app.Post("/token/refresh", auth.Refresh)
+	app.Post("/logout", auth.Protect(false), auth.Logout)
+	app.Post("/sessions/revoke-all", auth.Protect(false), auth.RevokeAllSessions)
+
+	// Email verification and password reset
+	app.Post("/email/verification", auth.Protect(false), auth.RequestEmailVerification)
+	app.Get("/email/verify", auth.VerifyEmail)
+	app.Post("/email/verify", auth.VerifyEmail)
+	app.Post("/password/forgot", auth.ForgotPassword)
+	app.Get("/password/reset", auth.ResetPasswordPage)
+	app.Post("/password/reset", auth.ResetPassword)
+
+	// Single sign-on (OIDC authorization-code flow)
+	app.Get("/auth/oidc/login", auth.OIDCLogin)
+	app.Get("/auth/oidc/callback", auth.OIDCCallback)
+
+	// API keys (JWT sessions only)
+	app.Post("/keys", auth.Protect(false), auth.CreateAPIKey)
+	app.Get("/keys", auth.Protect(false), auth.ListAPIKeys)
+	app.Patch("/keys/:id", auth.Protect(false), auth.UpdateAPIKey)
+	app.Delete("/keys/:id", auth.Protect(false), auth.RevokeAPIKey)
 
 	// File upload (authenticated)
-	app.Post("/upload", auth.Protect(false), handlers.UploadFile)
+	app.Post("/upload", auth.Protect(false), auth.RequireCapability(auth.CapUpload), handlers.UploadFile)
 
 	// Admin only
-	app.Post("/admin/reload", auth.Protect(true), handlers.ReloadGPTs)
+	admin := app.Group("/admin", auth.Protect(true))
+	admin.Post("/reload", handlers.ReloadGPTs)
+
+	// GPT definitions stored in the database, layered over configs/gpts
+	admin.Get("/gpts", handlers.ListGPTs)
+	admin.Post("/gpts", handlers.CreateGPT)
+	admin.Post("/gpts/import", handlers.ImportGPTs)
+	admin.Get("/gpts/:slug", handlers.GetGPT)
+	admin.Put("/gpts/:slug", handlers.UpdateGPT)
+	admin.Delete("/gpts/:slug", handlers.DeleteGPT)
+	admin.Get("/gpts/:slug/versions", handlers.ListGPTVersions)
+	admin.Get("/gpts/:slug/versions/:version", handlers.GetGPTVersion)
+	admin.Get("/gpts/:slug/diff", handlers.DiffGPTVersions)
+	admin.Post("/gpts/:slug/rollback", handlers.RollbackGPT)
+
+	// User management; every change is written to the audit log
+	admin.Get("/users", handlers.ListUsers)
+	admin.Get("/users/:id", handlers.GetUser)
+	admin.Patch("/users/:id", handlers.UpdateUser)
+	admin.Delete("/users/:id", handlers.DeleteUser)
+	admin.Post("/users/:id/disable", handlers.DisableUser)
+	admin.Post("/users/:id/enable", handlers.EnableUser)
+	admin.Post("/users/:id/reset-password", handlers.ResetUserPassword)
+	admin.Post("/users/:id/revoke-sessions", handlers.RevokeUserSessions)
+	admin.Get("/audit", handlers.ListAudit)
+
+	// Roles and groups (GPT access control)
+	admin.Get("/roles", handlers.ListRoles)
+	admin.Post("/roles", handlers.CreateRole)
+	admin.Delete("/roles/:role", handlers.DeleteRole)
+	admin.Get("/groups", handlers.ListGroups)
+	admin.Post("/groups", handlers.CreateGroup)
+	admin.Delete("/groups/:group", handlers.DeleteGroup)
+	admin.Put("/groups/:group/roles/:role", handlers.GrantGroupRole)
+	admin.Delete("/groups/:group/roles/:role", handlers.RevokeGroupRole)
+	admin.Put("/groups/:group/members/:id", handlers.AddGroupMember)
+	admin.Delete("/groups/:group/members/:id", handlers.RemoveGroupMember)
+	admin.Get("/users/:id/roles", handlers.UserRoles)
+	admin.Put("/users/:id/roles/:role", handlers.GrantUserRole)
+	admin.Delete("/users/:id/roles/:role", handlers.RevokeUserRole)
+
+	// Organizations (tenants) and their quotas
+	admin.Get("/orgs", handlers.ListOrgs)
+	admin.Post("/orgs", handlers.CreateOrg)
+	admin.Get("/orgs/:org", handlers.GetOrg)
+	admin.Patch("/orgs/:org", handlers.UpdateOrg)
+	admin.Delete("/orgs/:org", handlers.DeleteOrg)
+	admin.Get("/orgs/:org/members", handlers.ListOrgMembers)
+	admin.Put("/orgs/:org/members/:id", handlers.SetOrgMember)
+	admin.Delete("/orgs/:org/members/:id", handlers.RemoveOrgMember)
+
+	// Organization self-service for org admins
+	orgAdmin := app.Group("/org", auth.Protect(false), auth.RequireOrgAdmin())
+	orgAdmin.Get("/", handlers.MyOrg)
+	orgAdmin.Get("/members", handlers.MyOrgMembers)
+	orgAdmin.Put("/members/:id/role", handlers.SetMyOrgMemberRole)
+	orgAdmin.Delete("/members/:id", handlers.RemoveMyOrgMember)
+
+	// GPT catalog
+	app.Get("/gpts", auth.Protect(false), auth.RequireCapability(auth.CapChat), handlers.ListCatalog)
+
+	// HTTP chat (JSON or SSE)
+	app.Post("/v1/gpts/:slug/chat", auth.Protect(false), auth.RequireCapability(auth.CapChat), handlers.Chat)
+
+	// OpenAI-compatible facade: model = GPT slug
+	app.Get("/v1/models", auth.Protect(false), auth.RequireCapability(auth.CapChat), handlers.ListModels)
+	app.Post("/v1/chat/completions", auth.Protect(false), auth.RequireCapability(auth.CapChat), handlers.ChatCompletions)
 
 	// WebSocket chat
-	app.Use("/ws/:slug", auth.Protect(false), handlers.WSUpgrade)
+	app.Use("/ws/:slug", auth.Protect(false), auth.RequireCapability(auth.CapChat), handlers.WSUpgrade)
 	app.Get("/ws/:slug", websocket.New(handlers.HandleWS))
 
 	return app
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS chat_history_conversation_idx;
+ALTER TABLE chat_history
+  DROP COLUMN IF EXISTS role,
+  DROP COLUMN IF EXISTS conversation_id;
+DROP TABLE IF EXISTS conversations;
@@ -0,0 +1,2 @@
+-- bumping token_version invalidates every access and refresh token of the user
+ALTER TABLE users ADD COLUMN IF NOT EXISTS token_version INTEGER NOT NULL DEFAULT 0;
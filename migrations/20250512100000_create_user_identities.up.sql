@@ -0,0 +1,16 @@
+-- users signed in through an identity provider have no local password
+ALTER TABLE users ALTER COLUMN password DROP NOT NULL;
+
+-- links an OIDC (issuer, subject) pair to a local user
+CREATE TABLE IF NOT EXISTS user_identities (
+  id SERIAL PRIMARY KEY,
+  user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  issuer TEXT NOT NULL,
+  subject TEXT NOT NULL,
+  email TEXT,
+  created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+  last_login_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+  UNIQUE (issuer, subject)
+);
+
+CREATE INDEX IF NOT EXISTS user_identities_user_idx ON user_identities(user_id);
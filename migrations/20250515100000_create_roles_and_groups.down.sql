@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS group_members;
+DROP TABLE IF EXISTS group_roles;
+DROP TABLE IF EXISTS user_roles;
+DROP TABLE IF EXISTS groups;
+DROP TABLE IF EXISTS roles;
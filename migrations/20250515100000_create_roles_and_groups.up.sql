@@ -0,0 +1,50 @@
+-- roles gate access to GPTs with visibility "restricted" (see allowed_roles)
+CREATE TABLE IF NOT EXISTS roles (
+  id SERIAL PRIMARY KEY,
+  name TEXT UNIQUE NOT NULL,
+  description TEXT NOT NULL DEFAULT '',
+  created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- groups bundle roles; oidc_group keeps membership in sync with the
+-- identity provider's group of that name on every SSO login
+CREATE TABLE IF NOT EXISTS groups (
+  id SERIAL PRIMARY KEY,
+  name TEXT UNIQUE NOT NULL,
+  oidc_group TEXT UNIQUE,
+  created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS user_roles (
+  user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  role_id INTEGER NOT NULL REFERENCES roles(id) ON DELETE CASCADE,
+  PRIMARY KEY (user_id, role_id)
+);
+
+CREATE TABLE IF NOT EXISTS group_roles (
+  group_id INTEGER NOT NULL REFERENCES groups(id) ON DELETE CASCADE,
+  role_id INTEGER NOT NULL REFERENCES roles(id) ON DELETE CASCADE,
+  PRIMARY KEY (group_id, role_id)
+);
+
+CREATE TABLE IF NOT EXISTS group_members (
+  group_id INTEGER NOT NULL REFERENCES groups(id) ON DELETE CASCADE,
+  user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  PRIMARY KEY (group_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS group_members_user_idx ON group_members(user_id);
+
+-- roles used by the bundled GPT configs
+INSERT INTO roles(name, description) VALUES
+  ('clinician', 'Doctors and clinical staff (doctor-gpt)'),
+  ('analytics', 'Analytics team (canna-deep-insights)')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO groups(name) VALUES ('clinicians'), ('analytics-team')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO group_roles(group_id, role_id)
+SELECT g.id, r.id FROM groups g JOIN roles r
+  ON (g.name, r.name) IN (('clinicians', 'clinician'), ('analytics-team', 'analytics'))
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,4 @@
+ALTER TABLE uploads DROP COLUMN IF EXISTS org_id;
+ALTER TABLE conversations DROP COLUMN IF EXISTS org_id;
+ALTER TABLE users DROP COLUMN IF EXISTS org_role, DROP COLUMN IF EXISTS org_id;
+DROP TABLE IF EXISTS organizations;
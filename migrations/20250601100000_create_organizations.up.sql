@@ -0,0 +1,23 @@
+-- tenants; a NULL quota means unlimited
+CREATE TABLE IF NOT EXISTS organizations (
+  id SERIAL PRIMARY KEY,
+  slug TEXT UNIQUE NOT NULL,
+  name TEXT NOT NULL,
+  max_users INTEGER,
+  monthly_messages INTEGER,
+  max_upload_bytes BIGINT,
+  created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- users outside any organization only see global GPTs
+ALTER TABLE users
+  ADD COLUMN IF NOT EXISTS org_id INTEGER REFERENCES organizations(id) ON DELETE SET NULL,
+  ADD COLUMN IF NOT EXISTS org_role TEXT NOT NULL DEFAULT 'member';
+
+CREATE INDEX IF NOT EXISTS users_org_idx ON users(org_id);
+
+-- conversations and uploads stay with the organization they were made in
+ALTER TABLE conversations ADD COLUMN IF NOT EXISTS org_id INTEGER REFERENCES organizations(id) ON DELETE CASCADE;
+ALTER TABLE uploads ADD COLUMN IF NOT EXISTS org_id INTEGER REFERENCES organizations(id) ON DELETE CASCADE;
+
+CREATE INDEX IF NOT EXISTS uploads_org_idx ON uploads(org_id);
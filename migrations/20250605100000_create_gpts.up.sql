@@ -0,0 +1,10 @@
+-- GPT definitions managed through /admin/gpts; a stored definition takes
+-- precedence over a YAML file with the same slug
+CREATE TABLE IF NOT EXISTS gpts (
+  slug TEXT PRIMARY KEY,
+  definition JSONB NOT NULL,
+  created_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+  updated_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+  created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+  updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
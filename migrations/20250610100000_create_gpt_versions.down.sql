@@ -0,0 +1,2 @@
+ALTER TABLE chat_history DROP COLUMN IF EXISTS config_version;
+DROP TABLE IF EXISTS gpt_versions;
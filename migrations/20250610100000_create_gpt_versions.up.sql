@@ -0,0 +1,15 @@
+-- every distinct config a GPT has had; rows are never updated
+CREATE TABLE IF NOT EXISTS gpt_versions (
+  id BIGSERIAL PRIMARY KEY,
+  slug TEXT NOT NULL,
+  version INTEGER NOT NULL,
+  hash TEXT NOT NULL,
+  definition JSONB NOT NULL,
+  source TEXT NOT NULL,
+  author_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+  created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+  UNIQUE (slug, version)
+);
+
+-- the GPT version that produced each message
+ALTER TABLE chat_history ADD COLUMN IF NOT EXISTS config_version INTEGER;
@@ -0,0 +1,2 @@
+-- shown to GPTs as {{.User.Name}}; NULL falls back to the username
+ALTER TABLE users ADD COLUMN IF NOT EXISTS display_name TEXT;
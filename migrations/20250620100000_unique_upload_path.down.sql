@@ -0,0 +1 @@
+DROP INDEX IF EXISTS uploads_user_path_idx;
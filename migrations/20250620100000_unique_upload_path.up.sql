@@ -0,0 +1,5 @@
+-- re-uploading a file replaces its row; keep the newest of any duplicates
+DELETE FROM uploads a USING uploads b
+WHERE a.user_id = b.user_id AND a.path = b.path AND a.id < b.id;
+
+CREATE UNIQUE INDEX IF NOT EXISTS uploads_user_path_idx ON uploads(user_id, path);
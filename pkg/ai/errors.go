@@ -0,0 +1,117 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+
+	openai "github.com/openai/openai-go"
+)
+
+// ErrorKind classifies provider failures so callers can react
+// without knowing anything about the provider SDK.
+type ErrorKind string
+
+const (
+	KindRateLimited         ErrorKind = "rate_limited"
+	KindAuth                ErrorKind = "auth"
+	KindInvalidRequest      ErrorKind = "invalid_request"
+	KindTimeout             ErrorKind = "timeout"
+	KindUpstreamUnavailable ErrorKind = "upstream_unavailable"
+	KindRunFailed           ErrorKind = "run_failed"
+	KindCancelled           ErrorKind = "cancelled"
+)
+
+// Error is the typed error returned by every provider call.
+type Error struct {
+	Kind ErrorKind
+	Op   string
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s: %v", e.Op, e.Kind, e.Err)
+}
+
+func (e *Error) Unwrap() error { return e.Err }
+
+// Retryable reports whether the failure is transient and worth retrying.
+func (e *Error) Retryable() bool {
+	switch e.Kind {
+	case KindRateLimited, KindTimeout, KindUpstreamUnavailable:
+		return true
+	}
+	return false
+}
+
+// KindOf returns the ErrorKind of err, or "" if err is not an *Error.
+func KindOf(err error) ErrorKind {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Kind
+	}
+	return ""
+}
+
+// classify wraps a raw SDK/transport error from operation op into an *Error.
+func classify(op string, err error) *Error {
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+	return &Error{Kind: kindFor(err), Op: op, Err: err}
+}
+
+func kindFor(err error) ErrorKind {
+	var apiErr *openai.Error
+	if errors.As(err, &apiErr) {
+		switch code := apiErr.StatusCode; {
+		case code == http.StatusTooManyRequests:
+			return KindRateLimited
+		case code == http.StatusUnauthorized, code == http.StatusForbidden:
+			return KindAuth
+		case code == http.StatusRequestTimeout:
+			return KindTimeout
+		case code >= 500:
+			return KindUpstreamUnavailable
+		default:
+			return KindInvalidRequest
+		}
+	}
+	if errors.Is(err, context.Canceled) {
+		return KindCancelled
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return KindTimeout
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return KindTimeout
+	}
+	return KindUpstreamUnavailable
+}
+
+// runError converts a terminal, non-completed run into an *Error.
+func runError(run *openai.Run) *Error {
+	kind := KindRunFailed
+	switch {
+	case run.Status == openai.RunStatusCancelled:
+		kind = KindCancelled
+	case run.LastError.Code == "rate_limit_exceeded":
+		kind = KindRateLimited
+	}
+	msg := run.LastError.Message
+	if msg == "" && run.IncompleteDetails.Reason != "" {
+		msg = run.IncompleteDetails.Reason
+	}
+	if msg == "" {
+		msg = "no details"
+	}
+	return &Error{
+		Kind: kind,
+		Op:   "assistant run",
+		Err:  fmt.Errorf("run %s ended with status %q: %s", run.ID, run.Status, msg),
+	}
+}
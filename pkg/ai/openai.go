@@ -15,24 +15,31 @@ import (
 
     openai "github.com/openai/openai-go"
     "github.com/openai/openai-go/option"
+    "github.com/openai/openai-go/packages/pagination"
+    "github.com/openai/openai-go/shared"
 )
 
+// runPollInterval is how often an in-flight run's status is checked.
+const runPollInterval = time.Second
+
 // AI wraps OpenAI client + our assistant resources.
 type AI struct {
     client        *openai.Client
     model         string
     vectorStoreID string
     assistantID   string
+    fileIDs       []string
+    retry         retryPolicy
 }
 
 // NewAI will:
 // 1. Init client
 // 2. Create vector store named `vsName`
 // 3. Upload all files (CSV → text if needed) into it
-// 4. Create an assistant named `assistantName` using `model`
-//    with File Search, Code Interpreter, Web Search, Image Gen tools
+// 4. Create an assistant named `assistantName` using `model` and, if set,
+//    `temperature`, with File Search, Code Interpreter, Web Search, Image Gen tools
 // 5. Return an *AI you can immediately call Chat() on.
-func NewAI(ctx context.Context, model, systemPrompt, assistantName string, filePaths []string) (*AI, error) {
+func NewAI(ctx context.Context, model, systemPrompt, assistantName string, filePaths []string, temperature *float64) (*AI, error) {
     // 0️⃣ Get API key
     apiKey := os.Getenv("OPENAI_API_KEY")
     if apiKey == "" {
@@ -40,15 +47,16 @@ func NewAI(ctx context.Context, model, systemPrompt, assistantName string, fileP
     }
 
     // 1️⃣ Init client
-    client := openai.NewClient(option.WithAPIKey(apiKey))
+    // SDK retries are disabled; withRetry owns backoff and classification
+    client := openai.NewClient(option.WithAPIKey(apiKey), option.WithMaxRetries(0))
     client.Config.AssistantVersion = "v2" // enable Assistants API v2
 
     // Prepare AI struct
-    ai := &AI{client: &client, model: model}
+    ai := &AI{client: &client, model: model, retry: loadRetryPolicy()}
 
     // 2️⃣ Create assistant with default tools
     log.Printf("Creating assistant %q with model %s", assistantName, model)
-    asst, err := client.Beta.Assistants.New(ctx, openai.BetaAssistantNewParams{
+    params := openai.BetaAssistantNewParams{
         Name:         openai.String(assistantName),
         Model:        model,
         Instructions: openai.String(systemPrompt),
@@ -58,18 +66,26 @@ func NewAI(ctx context.Context, model, systemPrompt, assistantName string, fileP
             {OfWebBrowser: &openai.WebBrowserToolParam{}},
             {OfImageGeneration: &openai.ImageGenerationToolParam{}},
         },
+    }
+    if temperature != nil {
+        params.Temperature = openai.Float(*temperature)
+    }
+    asst, err := withRetry(ctx, ai.retry, "assistant creation", func() (*openai.Assistant, error) {
+        return client.Beta.Assistants.New(ctx, params)
     })
     if err != nil {
-        return nil, fmt.Errorf("assistant creation: %w", err)
+        return nil, err
     }
     log.Printf("🤖 Assistant %q created (ID=%s)", assistantName, asst.ID)
     ai.assistantID = asst.ID
 
     // 3️⃣ Create vector store
     vsName := fmt.Sprintf("store-%s-%s", model, assistantName)
-    vs, err := client.VectorStores.New(ctx, openai.VectorStoreNewParams{Name: openai.String(vsName)})
+    vs, err := withRetry(ctx, ai.retry, "vector store creation", func() (*openai.VectorStore, error) {
+        return client.VectorStores.New(ctx, openai.VectorStoreNewParams{Name: openai.String(vsName)})
+    })
     if err != nil {
-        return nil, fmt.Errorf("vector store creation: %w", err)
+        return nil, err
     }
     log.Printf("🗄️  Vector store %q created (ID=%s)", vsName, vs.ID)
     ai.vectorStoreID = vs.ID
@@ -93,72 +109,256 @@ func NewAI(ctx context.Context, model, systemPrompt, assistantName string, fileP
         }
 
         log.Printf("📁 Uploading %s (size=%d)", uploadName, len(data))
-        bf := bytes.NewReader(data)
-        file, err := client.Files.Upload(ctx, openai.FileNewParams{
-            Purpose: openai.FilePurposeAssistants,
-            File:    bf,
-            Name:    openai.String(uploadName),
+        file, err := withRetry(ctx, ai.retry, "upload "+uploadName, func() (*openai.FileObject, error) {
+            // fresh reader per attempt; a failed attempt may have consumed the previous one
+            return client.Files.Upload(ctx, openai.FileNewParams{
+                Purpose: openai.FilePurposeAssistants,
+                File:    bytes.NewReader(data),
+                Name:    openai.String(uploadName),
+            })
         })
         if err != nil {
-            return nil, fmt.Errorf("upload %s: %w", uploadName, err)
+            return nil, err
         }
         fileIDs = append(fileIDs, file.ID)
         log.Printf("   → file ID=%s", file.ID)
     }
+    ai.fileIDs = fileIDs
 
     // Associate all uploaded files with the vector store
-    _, err = client.VectorStores.Files.Add(ctx, ai.vectorStoreID, openai.VectorStoreFilesAddParams{
-        FileIDs: fileIDs,
+    _, err = withRetry(ctx, ai.retry, "add files to vector store", func() (any, error) {
+        return client.VectorStores.Files.Add(ctx, ai.vectorStoreID, openai.VectorStoreFilesAddParams{
+            FileIDs: fileIDs,
+        })
     })
     if err != nil {
-        return nil, fmt.Errorf("add files to vector store: %w", err)
+        return nil, err
     }
 
     // Update assistant so File Search can use this VS
-    _, err = client.Beta.Assistants.Update(ctx, openai.BetaAssistantUpdateParams{
-        AssistantID: asst.ID,
-        ToolResources: openai.BetaAssistantUpdateParamsToolResources{
-            FileSearch: openai.BetaAssistantUpdateParamsToolResourcesFileSearch{
-                VectorStoreIDs: []string{ai.vectorStoreID},
+    _, err = withRetry(ctx, ai.retry, "assistant update", func() (*openai.Assistant, error) {
+        return client.Beta.Assistants.Update(ctx, openai.BetaAssistantUpdateParams{
+            AssistantID: asst.ID,
+            ToolResources: openai.BetaAssistantUpdateParamsToolResources{
+                FileSearch: openai.BetaAssistantUpdateParamsToolResourcesFileSearch{
+                    VectorStoreIDs: []string{ai.vectorStoreID},
+                },
             },
-        },
+        })
     })
     if err != nil {
-        return nil, fmt.Errorf("assistant update: %w", err)
+        return nil, err
     }
 
     return ai, nil
 }
 
-// Chat sends a single user query and returns the assistant's reply.
-func (ai *AI) Chat(ctx context.Context, question string) (string, error) {
-    // 1️⃣ Create thread with user message
-    thr, err := ai.client.Beta.Threads.New(ctx, openai.BetaThreadNewParams{
-        Messages: []openai.BetaThreadNewParamsMessage{
-            {
-                Role: "user",
-                Content: openai.BetaThreadNewParamsMessageContentUnion{
-                    OfString: openai.String(question),
-                },
+// NewThread starts an empty conversation thread and returns its ID.
+func (ai *AI) NewThread(ctx context.Context) (string, error) {
+    thr, err := withRetry(ctx, ai.retry, "create thread", func() (*openai.Thread, error) {
+        return ai.client.Beta.Threads.New(ctx, openai.BetaThreadNewParams{})
+    })
+    if err != nil {
+        return "", err
+    }
+    return thr.ID, nil
+}
+
+// RunOptions adjust a single run of the assistant.
+type RunOptions struct {
+    Instructions           string      // replace the assistant's instructions
+    AdditionalInstructions string      // appended to the instructions
+    Schema                 *JSONSchema // ask for structured output; nil for text
+}
+
+// JSONSchema asks the model for a reply that is JSON matching Schema.
+type JSONSchema struct {
+    Name        string
+    Description string
+    Strict      bool
+    Schema      map[string]any
+}
+
+// params builds the run request for opts
+func (ai *AI) params(opts RunOptions) openai.BetaThreadRunNewParams {
+    params := openai.BetaThreadRunNewParams{AssistantID: ai.assistantID}
+    if opts.Instructions != "" {
+        params.Instructions = openai.String(opts.Instructions)
+    }
+    if opts.AdditionalInstructions != "" {
+        params.AdditionalInstructions = openai.String(opts.AdditionalInstructions)
+    }
+    if s := opts.Schema; s != nil {
+        format := shared.ResponseFormatJSONSchemaJSONSchemaParam{
+            Name:   s.Name,
+            Strict: openai.Bool(s.Strict),
+            Schema: s.Schema,
+        }
+        if s.Description != "" {
+            format.Description = openai.String(s.Description)
+        }
+        params.ResponseFormat = openai.AssistantResponseFormatOptionUnionParam{
+            OfJSONSchema: &shared.ResponseFormatJSONSchemaParam{JSONSchema: format},
+        }
+    }
+    return params
+}
+
+// Chat appends question to the thread, runs the assistant on it with opts
+// and returns the reply. Every error it returns is an *Error.
+func (ai *AI) Chat(ctx context.Context, threadID, question string, opts RunOptions) (string, error) {
+    // 1️⃣ Add user message to thread
+    _, err := withRetry(ctx, ai.retry, "add message", func() (*openai.Message, error) {
+        return ai.client.Beta.Threads.Messages.New(ctx, threadID, openai.BetaThreadMessageNewParams{
+            Role: openai.BetaThreadMessageNewParamsRoleUser,
+            Content: openai.BetaThreadMessageNewParamsContentUnion{
+                OfString: openai.String(question),
             },
-        },
+        })
     })
     if err != nil {
-        return "", fmt.Errorf("create thread: %w", err)
+        return "", err
     }
 
-    // 2️⃣ Run assistant on thread
-    run, err := ai.client.Beta.Threads.Runs.NewAndPoll(ctx, thr.ID, openai.BetaThreadRunNewParams{
-        AssistantID: ai.assistantID,
-    }, 0)
+    // 2️⃣ Run assistant on thread and collect its reply
+    resp, _, err := ai.run(ctx, threadID, ai.params(opts))
+    return resp, err
+}
+
+// Retry answers again in the thread after a reply that wasn't usable:
+// correction is sent, the assistant runs on it and the new reply is
+// returned. The unusable reply and the correction are then deleted, so the
+// thread keeps only the latest reply to the question.
+func (ai *AI) Retry(ctx context.Context, threadID, correction string, opts RunOptions) (string, error) {
+    stale, err := ai.lastReply(ctx, threadID)
     if err != nil {
-        return "", fmt.Errorf("assistant run: %w", err)
+        return "", err
     }
+    msg, err := withRetry(ctx, ai.retry, "add message", func() (*openai.Message, error) {
+        return ai.client.Beta.Threads.Messages.New(ctx, threadID, openai.BetaThreadMessageNewParams{
+            Role: openai.BetaThreadMessageNewParamsRoleUser,
+            Content: openai.BetaThreadMessageNewParamsContentUnion{
+                OfString: openai.String(correction),
+            },
+        })
+    })
+    if err != nil {
+        return "", err
+    }
+    defer ai.deleteMessages(threadID, append(stale, msg.ID))
 
-    // 3️⃣ Retrieve assistant’s reply
-    page, err := ai.client.Beta.Threads.Messages.List(ctx, thr.ID, openai.BetaThreadMessageListParams{})
+    resp, _, err := ai.run(ctx, threadID, ai.params(opts))
+    return resp, err
+}
+
+// DiscardReply deletes the assistant's last reply from the thread, e.g.
+// one that was never delivered because it stayed unusable.
+func (ai *AI) DiscardReply(ctx context.Context, threadID string) error {
+    ids, err := ai.lastReply(ctx, threadID)
     if err != nil {
-        return "", fmt.Errorf("list messages: %w", err)
+        return err
+    }
+    ai.deleteMessages(threadID, ids)
+    return nil
+}
+
+// lastReply returns the IDs of the assistant messages after the thread's
+// last user message
+func (ai *AI) lastReply(ctx context.Context, threadID string) ([]string, error) {
+    page, err := withRetry(ctx, ai.retry, "list messages", func() (*pagination.CursorPage[openai.Message], error) {
+        return ai.client.Beta.Threads.Messages.List(ctx, threadID, openai.BetaThreadMessageListParams{
+            Order: openai.BetaThreadMessageListParamsOrderDesc,
+            Limit: openai.Int(20),
+        })
+    })
+    if err != nil {
+        return nil, err
+    }
+    var ids []string
+    for _, m := range page.Data {
+        if m.Role != "assistant" {
+            break
+        }
+        ids = append(ids, m.ID)
+    }
+    return ids, nil
+}
+
+// deleteMessages removes messages from a thread; failures are only logged.
+func (ai *AI) deleteMessages(threadID string, ids []string) {
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+    for _, id := range ids {
+        if _, err := ai.client.Beta.Threads.Messages.Delete(ctx, threadID, id); err != nil {
+            log.Printf("⚠️  delete message %s: %v", id, err)
+        }
+    }
+}
+
+// Message is one turn of a conversation the client keeps itself.
+type Message struct {
+    Role    string // user | assistant
+    Content string
+}
+
+// Usage is the token count of one run.
+type Usage struct {
+    PromptTokens     int64 `json:"prompt_tokens"`
+    CompletionTokens int64 `json:"completion_tokens"`
+    TotalTokens      int64 `json:"total_tokens"`
+}
+
+// Complete answers a whole client-held conversation (chat-completions
+// style) in a throwaway thread, with opts applying to this run only.
+func (ai *AI) Complete(ctx context.Context, messages []Message, opts RunOptions) (string, Usage, error) {
+    params := openai.BetaThreadNewParams{}
+    for _, m := range messages {
+        params.Messages = append(params.Messages, openai.BetaThreadNewParamsMessage{
+            Role:    m.Role,
+            Content: openai.BetaThreadNewParamsMessageContentUnion{OfString: openai.String(m.Content)},
+        })
+    }
+    thr, err := withRetry(ctx, ai.retry, "create thread", func() (*openai.Thread, error) {
+        return ai.client.Beta.Threads.New(ctx, params)
+    })
+    if err != nil {
+        return "", Usage{}, err
+    }
+    defer ai.deleteThread(thr.ID)
+
+    resp, run, err := ai.run(ctx, thr.ID, ai.params(opts))
+    if err != nil {
+        return "", Usage{}, err
+    }
+    return resp, Usage{
+        PromptTokens:     run.Usage.PromptTokens,
+        CompletionTokens: run.Usage.CompletionTokens,
+        TotalTokens:      run.Usage.TotalTokens,
+    }, nil
+}
+
+// run starts a run on the thread, waits for it and returns the text of the
+// messages it produced. A failed/expired/incomplete run is an error.
+func (ai *AI) run(ctx context.Context, threadID string, params openai.BetaThreadRunNewParams) (string, *openai.Run, error) {
+    run, err := withRetry(ctx, ai.retry, "assistant run", func() (*openai.Run, error) {
+        run, err := ai.client.Beta.Threads.Runs.New(ctx, threadID, params)
+        if err != nil {
+            return nil, err
+        }
+        return ai.waitRun(ctx, threadID, run)
+    })
+    if err != nil {
+        return "", nil, err
+    }
+
+    page, err := withRetry(ctx, ai.retry, "list messages", func() (*pagination.CursorPage[openai.Message], error) {
+        return ai.client.Beta.Threads.Messages.List(ctx, threadID, openai.BetaThreadMessageListParams{
+            RunID: openai.String(run.ID),
+            Order: openai.BetaThreadMessageListParamsOrderAsc,
+        })
+    })
+    if err != nil {
+        return "", nil, err
     }
 
     var resp string
@@ -172,9 +372,80 @@ func (ai *AI) Chat(ctx context.Context, question string) (string, error) {
         }
     }
     if strings.TrimSpace(resp) == "" {
-        return "", fmt.Errorf("assistant returned empty response")
+        return "", nil, &Error{Kind: KindRunFailed, Op: "assistant run", Err: fmt.Errorf("assistant returned empty response")}
+    }
+    return resp, run, nil
+}
+
+// deleteThread removes a throwaway thread; failures are only logged.
+func (ai *AI) deleteThread(threadID string) {
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+    if _, err := ai.client.Beta.Threads.Delete(ctx, threadID); err != nil {
+        log.Printf("⚠️  delete thread %s: %v", threadID, err)
+    }
+}
+
+// Close deletes the assistant, its vector store and the uploaded files
+// upstream. Failures are only logged; the AI must not be used afterwards.
+func (ai *AI) Close() {
+    ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+    defer cancel()
+    if _, err := ai.client.Beta.Assistants.Delete(ctx, ai.assistantID); err != nil {
+        log.Printf("⚠️  delete assistant %s: %v", ai.assistantID, err)
+    }
+    if _, err := ai.client.VectorStores.Delete(ctx, ai.vectorStoreID); err != nil {
+        log.Printf("⚠️  delete vector store %s: %v", ai.vectorStoreID, err)
+    }
+    for _, id := range ai.fileIDs {
+        if _, err := ai.client.Files.Delete(ctx, id); err != nil {
+            log.Printf("⚠️  delete file %s: %v", id, err)
+        }
+    }
+    log.Printf("🗑️  Assistant %s removed", ai.assistantID)
+}
+
+// waitRun polls run until it completes. If ctx ends first (client cancel,
+// disconnect) or polling fails, the upstream run is cancelled so it stops
+// consuming tokens.
+func (ai *AI) waitRun(ctx context.Context, threadID string, run *openai.Run) (*openai.Run, error) {
+    ticker := time.NewTicker(runPollInterval)
+    defer ticker.Stop()
+    for {
+        switch run.Status {
+        case openai.RunStatusCompleted:
+            return run, nil
+        case openai.RunStatusFailed, openai.RunStatusExpired, openai.RunStatusIncomplete,
+            openai.RunStatusCancelled, openai.RunStatusRequiresAction:
+            return nil, runError(run)
+        }
+        select {
+        case <-ctx.Done():
+            ai.cancelRun(threadID, run.ID)
+            return nil, classify("assistant run", ctx.Err())
+        case <-ticker.C:
+        }
+        next, err := withRetry(ctx, ai.retry, "poll run", func() (*openai.Run, error) {
+            return ai.client.Beta.Threads.Runs.Get(ctx, threadID, run.ID)
+        })
+        if err != nil {
+            ai.cancelRun(threadID, run.ID)
+            return nil, err
+        }
+        run = next
+    }
+}
+
+// cancelRun asks the provider to stop a run. It uses its own context because
+// the caller's has usually been cancelled already.
+func (ai *AI) cancelRun(threadID, runID string) {
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+    if _, err := ai.client.Beta.Threads.Runs.Cancel(ctx, threadID, runID); err != nil {
+        log.Printf("⚠️  cancel run %s: %v", runID, err)
+        return
     }
-    return resp, nil
+    log.Printf("🛑 Run %s cancelled", runID)
 }
 
 // csvToText converts raw CSV bytes to plain text for better embeddings.
@@ -0,0 +1,72 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math/rand"
+	"strconv"
+	"time"
+
+	openai "github.com/openai/openai-go"
+
+	"github.com/zeelrupapara/custom-ai-server/pkg/config"
+)
+
+// retryPolicy controls how transient provider errors are retried.
+type retryPolicy struct {
+	maxRetries int
+	baseDelay  time.Duration
+	maxDelay   time.Duration
+}
+
+func loadRetryPolicy() retryPolicy {
+	cfg := config.Load()
+	return retryPolicy{
+		maxRetries: cfg.AIMaxRetries,
+		baseDelay:  cfg.AIRetryBaseDelay,
+		maxDelay:   cfg.AIRetryMaxDelay,
+	}
+}
+
+// withRetry runs fn, retrying retryable failures with full-jitter
+// exponential backoff. Every returned error is an *Error.
+func withRetry[T any](ctx context.Context, p retryPolicy, op string, fn func() (T, error)) (T, error) {
+	for attempt := 0; ; attempt++ {
+		res, err := fn()
+		if err == nil {
+			return res, nil
+		}
+		aerr := classify(op, err)
+		if !aerr.Retryable() || attempt >= p.maxRetries {
+			return res, aerr
+		}
+		delay := p.backoff(attempt, aerr)
+		log.Printf("⏳ %s failed (%s), retry %d/%d in %s", op, aerr.Kind, attempt+1, p.maxRetries, delay)
+		select {
+		case <-ctx.Done():
+			return res, classify(op, ctx.Err())
+		case <-time.After(delay):
+		}
+	}
+}
+
+// backoff returns a random delay in [0, min(maxDelay, baseDelay*2^attempt)],
+// honouring an upstream Retry-After header when one is present. maxDelay
+// caps the header too, so a provider can't stall a request for minutes.
+func (p retryPolicy) backoff(attempt int, err *Error) time.Duration {
+	var apiErr *openai.Error
+	if errors.As(err, &apiErr) && apiErr.Response != nil {
+		if secs, perr := strconv.Atoi(apiErr.Response.Header.Get("Retry-After")); perr == nil && secs > 0 {
+			return min(time.Duration(secs)*time.Second, p.maxDelay)
+		}
+	}
+	ceiling := p.baseDelay << attempt
+	if ceiling <= 0 || ceiling > p.maxDelay {
+		ceiling = p.maxDelay
+	}
+	if ceiling <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(ceiling) + 1))
+}
@@ -0,0 +1,94 @@
+package audit
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"go.uber.org/zap"
+
+	"github.com/zeelrupapara/custom-ai-server/pkg/db"
+)
+
+// Entry is one audit_log row
+type Entry struct {
+	ID         int64          `json:"id"`
+	ActorID    *int           `json:"actor_id"`
+	Action     string         `json:"action"`
+	TargetType string         `json:"target_type"`
+	TargetID   string         `json:"target_id"`
+	Details    map[string]any `json:"details"`
+	IP         string         `json:"ip"`
+	CreatedAt  time.Time      `json:"created_at"`
+}
+
+// Record appends an entry. A failed write is logged rather than returned
+// so the audited action, which already happened, is not reported as failed.
+func Record(ctx context.Context, e Entry) {
+	if e.Details == nil {
+		e.Details = map[string]any{}
+	}
+	_, err := db.PG.Exec(ctx,
+		`INSERT INTO audit_log(actor_id,action,target_type,target_id,details,ip) VALUES($1,$2,$3,$4,$5,$6)`,
+		e.ActorID, e.Action, e.TargetType, e.TargetID, e.Details, e.IP)
+	if err != nil {
+		zap.L().Error("audit log write failed", zap.String("action", e.Action), zap.Error(err))
+	}
+}
+
+// Filter narrows List; zero fields match everything
+type Filter struct {
+	ActorID    int
+	Action     string
+	TargetType string
+	TargetID   string
+	BeforeID   int64 // page backwards from this entry
+	Limit      int
+}
+
+// List returns matching entries, newest first
+func List(ctx context.Context, f Filter) ([]Entry, error) {
+	var (
+		where []string
+		args  []any
+	)
+	add := func(cond string, v any) {
+		args = append(args, v)
+		where = append(where, fmt.Sprintf(cond, len(args)))
+	}
+	if f.ActorID != 0 {
+		add("actor_id=$%d", f.ActorID)
+	}
+	if f.Action != "" {
+		add("action=$%d", f.Action)
+	}
+	if f.TargetType != "" {
+		add("target_type=$%d", f.TargetType)
+	}
+	if f.TargetID != "" {
+		add("target_id=$%d", f.TargetID)
+	}
+	if f.BeforeID != 0 {
+		add("id<$%d", f.BeforeID)
+	}
+	if f.Limit <= 0 || f.Limit > 500 {
+		f.Limit = 100
+	}
+	q := `SELECT id,actor_id,action,target_type,target_id,details,ip,created_at FROM audit_log`
+	if len(where) > 0 {
+		q += " WHERE " + strings.Join(where, " AND ")
+	}
+	q += fmt.Sprintf(" ORDER BY id DESC LIMIT %d", f.Limit)
+
+	rows, err := db.PG.Query(ctx, q, args...)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(r pgx.CollectableRow) (Entry, error) {
+		var e Entry
+		err := r.Scan(&e.ID, &e.ActorID, &e.Action, &e.TargetType, &e.TargetID, &e.Details, &e.IP, &e.CreatedAt)
+		return e, err
+	})
+}
@@ -0,0 +1,245 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5"
+
+	"github.com/zeelrupapara/custom-ai-server/pkg/db"
+)
+
+// APIKeyPrefix marks bearer tokens that are API keys rather than JWTs
+const APIKeyPrefix = "cas_"
+
+// Capabilities an API key can be granted
+const (
+	CapChat   = "chat"   // WebSocket, HTTP chat and /v1 endpoints
+	CapUpload = "upload" // POST /upload
+	CapAdmin  = "admin"  // admin routes, only honoured for admin users
+)
+
+var knownCaps = []string{CapChat, CapUpload, CapAdmin}
+
+// Scope restricts what a request authenticated by API key may do.
+// JWT-authenticated requests carry no scope and are unrestricted.
+type Scope struct {
+	KeyID        int
+	Slugs        []string // empty means every GPT
+	Capabilities []string
+}
+
+// AllowsSlug reports whether the scope covers GPT slug
+func (s *Scope) AllowsSlug(slug string) bool {
+	return s == nil || len(s.Slugs) == 0 || slices.Contains(s.Slugs, slug)
+}
+
+// Can reports whether the scope grants capability
+func (s *Scope) Can(capability string) bool {
+	return s == nil || slices.Contains(s.Capabilities, capability)
+}
+
+// ScopeOf returns the API key scope of the request, or nil for JWTs
+func ScopeOf(c *fiber.Ctx) *Scope {
+	s, _ := c.Locals("apiKeyScope").(*Scope)
+	return s
+}
+
+// RequireCapability rejects API-key requests whose key lacks capability.
+// Use after Protect.
+func RequireCapability(capability string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if !ScopeOf(c).Can(capability) {
+			return fiber.NewError(fiber.StatusForbidden, "API key lacks the "+capability+" capability")
+		}
+		return c.Next()
+	}
+}
+
+// protectAPIKey authenticates an API key bearer token for Protect
+func protectAPIKey(c *fiber.Ctx, key string, requireAdmin bool) error {
+	var (
+		scope   Scope
+		userID  int
+		isAdmin bool
+		orgID   int
+		orgSlug string
+		orgRole string
+	)
+	err := db.PG.QueryRow(c.Context(),
+		`SELECT k.id, k.user_id, k.slugs, k.capabilities, u.is_admin,
+		        COALESCE(u.org_id,0), COALESCE(o.slug,''), u.org_role
+		 FROM api_keys k JOIN users u ON u.id = k.user_id
+		 LEFT JOIN organizations o ON o.id = u.org_id
+		 WHERE k.key_hash=$1 AND k.revoked_at IS NULL AND u.disabled_at IS NULL`, hashToken(key)).
+		Scan(&scope.KeyID, &userID, &scope.Slugs, &scope.Capabilities, &isAdmin, &orgID, &orgSlug, &orgRole)
+	if err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			log.Printf("api key lookup: %v", err)
+		}
+		return fiber.ErrUnauthorized
+	}
+	if requireAdmin && !(isAdmin && scope.Can(CapAdmin)) {
+		return fiber.ErrForbidden
+	}
+	go touchAPIKey(scope.KeyID)
+	c.Locals("userID", userID)
+	c.Locals("isAdmin", isAdmin)
+	// org administration stays with sessions, like API key management
+	setOrg(c, orgID, orgSlug, false)
+	c.Locals("apiKeyScope", &scope)
+	return c.Next()
+}
+
+// touchAPIKey records last use, at most once a minute per key
+func touchAPIKey(id int) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err := db.PG.Exec(ctx,
+		`UPDATE api_keys SET last_used_at=NOW()
+		 WHERE id=$1 AND (last_used_at IS NULL OR last_used_at < NOW() - INTERVAL '1 minute')`, id)
+	if err != nil {
+		log.Printf("api key %d last_used_at: %v", id, err)
+	}
+}
+
+// apiKeyView is what listings return; the key itself is never shown again
+type apiKeyView struct {
+	ID           int        `json:"id"`
+	Label        string     `json:"label"`
+	Prefix       string     `json:"prefix"`
+	Slugs        []string   `json:"slugs"`
+	Capabilities []string   `json:"capabilities"`
+	CreatedAt    time.Time  `json:"created_at"`
+	LastUsedAt   *time.Time `json:"last_used_at"`
+	RevokedAt    *time.Time `json:"revoked_at"`
+}
+
+// sessionOnly keeps API keys from minting or managing other API keys
+func sessionOnly(c *fiber.Ctx) error {
+	if ScopeOf(c) != nil {
+		return fiber.NewError(fiber.StatusForbidden, "API keys cannot manage API keys")
+	}
+	return nil
+}
+
+// CreateAPIKey issues a new key for the caller. The plaintext key is in
+// the response only; it is stored hashed.
+func CreateAPIKey(c *fiber.Ctx) error {
+	if err := sessionOnly(c); err != nil {
+		return err
+	}
+	type req struct {
+		Label        string   `json:"label"`
+		Slugs        []string `json:"slugs"`
+		Capabilities []string `json:"capabilities"`
+	}
+	var body req
+	if err := c.BodyParser(&body); err != nil {
+		return fiber.ErrBadRequest
+	}
+	if len(body.Capabilities) == 0 {
+		body.Capabilities = []string{CapChat}
+	}
+	for _, cp := range body.Capabilities {
+		if !slices.Contains(knownCaps, cp) {
+			return fiber.NewError(fiber.StatusBadRequest, "unknown capability "+cp)
+		}
+	}
+	if body.Slugs == nil {
+		body.Slugs = []string{}
+	}
+
+	key := APIKeyPrefix + randomHex(24)
+	v := apiKeyView{
+		Label:        strings.TrimSpace(body.Label),
+		Prefix:       key[:len(APIKeyPrefix)+8],
+		Slugs:        body.Slugs,
+		Capabilities: body.Capabilities,
+	}
+	err := db.PG.QueryRow(c.Context(),
+		`INSERT INTO api_keys(user_id,label,prefix,key_hash,slugs,capabilities)
+		 VALUES($1,$2,$3,$4,$5,$6) RETURNING id, created_at`,
+		c.Locals("userID").(int), v.Label, v.Prefix, hashToken(key), v.Slugs, v.Capabilities).
+		Scan(&v.ID, &v.CreatedAt)
+	if err != nil {
+		return fiber.ErrInternalServerError
+	}
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"key": key, "api_key": v})
+}
+
+// ListAPIKeys returns the caller's keys, including revoked ones
+func ListAPIKeys(c *fiber.Ctx) error {
+	if err := sessionOnly(c); err != nil {
+		return err
+	}
+	rows, err := db.PG.Query(c.Context(),
+		`SELECT id,label,prefix,slugs,capabilities,created_at,last_used_at,revoked_at
+		 FROM api_keys WHERE user_id=$1 ORDER BY id`, c.Locals("userID").(int))
+	if err != nil {
+		return fiber.ErrInternalServerError
+	}
+	keys, err := pgx.CollectRows(rows, func(r pgx.CollectableRow) (apiKeyView, error) {
+		var v apiKeyView
+		err := r.Scan(&v.ID, &v.Label, &v.Prefix, &v.Slugs, &v.Capabilities, &v.CreatedAt, &v.LastUsedAt, &v.RevokedAt)
+		return v, err
+	})
+	if err != nil {
+		return fiber.ErrInternalServerError
+	}
+	return c.JSON(keys)
+}
+
+// UpdateAPIKey relabels one of the caller's keys
+func UpdateAPIKey(c *fiber.Ctx) error {
+	if err := sessionOnly(c); err != nil {
+		return err
+	}
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return fiber.ErrBadRequest
+	}
+	type req struct {
+		Label string `json:"label"`
+	}
+	var body req
+	if err := c.BodyParser(&body); err != nil {
+		return fiber.ErrBadRequest
+	}
+	tag, err := db.PG.Exec(c.Context(),
+		`UPDATE api_keys SET label=$1 WHERE id=$2 AND user_id=$3`,
+		strings.TrimSpace(body.Label), id, c.Locals("userID").(int))
+	if err != nil {
+		return fiber.ErrInternalServerError
+	}
+	if tag.RowsAffected() == 0 {
+		return fiber.ErrNotFound
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// RevokeAPIKey permanently disables one of the caller's keys
+func RevokeAPIKey(c *fiber.Ctx) error {
+	if err := sessionOnly(c); err != nil {
+		return err
+	}
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return fiber.ErrBadRequest
+	}
+	tag, err := db.PG.Exec(c.Context(),
+		`UPDATE api_keys SET revoked_at=NOW()
+		 WHERE id=$1 AND user_id=$2 AND revoked_at IS NULL`, id, c.Locals("userID").(int))
+	if err != nil {
+		return fiber.ErrInternalServerError
+	}
+	if tag.RowsAffected() == 0 {
+		return fiber.ErrNotFound
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
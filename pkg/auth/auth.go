@@ -3,84 +3,134 @@ package auth
 import (
 	"errors"
 	"fmt"
-	"time"
+	"math"
+	"strconv"
+	"strings"
 
 	"github.com/gofiber/fiber/v2"
-	"github.com/golang-jwt/jwt/v4"
 	"github.com/jackc/pgx/v5"
+	"go.uber.org/zap"
 	"golang.org/x/crypto/bcrypt"
 
-	"github.com/zeelrupapara/custom-ai-server/pkg/config"
 	"github.com/zeelrupapara/custom-ai-server/pkg/db"
 )
 
-// Register creates a new user
+// Register creates a new user after validating the username and the
+// password against the configured policy. An optional email is sent a
+// verification link.
 func Register(c *fiber.Ctx) error {
 	type req struct {
 		Username string `json:"username"`
 		Password string `json:"password"`
+		Email    string `json:"email"`
 	}
 	var body req
 	if err := c.BodyParser(&body); err != nil {
 		return fiber.ErrBadRequest
 	}
-	fmt.Println(body)
-	hash, _ := bcrypt.GenerateFromPassword([]byte(body.Password), bcrypt.DefaultCost)
-	_, err := db.PG.Exec(c.Context(),
-		`INSERT INTO users(username,password,is_admin)
-		 VALUES($1,$2,false)`, body.Username, string(hash))
+	body.Username = strings.TrimSpace(body.Username)
+	body.Email = strings.TrimSpace(body.Email)
+	fields := fiber.Map{}
+	if msg := validateUsername(body.Username); msg != "" {
+		fields["username"] = msg
+	}
+	if msg := validatePassword(body.Password, body.Username); msg != "" {
+		fields["password"] = msg
+	}
+	if body.Email != "" {
+		if msg := validateEmail(body.Email); msg != "" {
+			fields["email"] = msg
+		}
+	}
+	if len(fields) > 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid registration", "fields": fields})
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(body.Password), bcrypt.DefaultCost)
 	if err != nil {
-		return fiber.ErrConflict
+		return fiber.ErrInternalServerError
+	}
+	var email *string
+	if body.Email != "" {
+		email = &body.Email
+	}
+	var id int
+	err = db.PG.QueryRow(c.Context(),
+		`INSERT INTO users(username,password,is_admin,email)
+		 VALUES($1,$2,false,$3) RETURNING id`, body.Username, string(hash), email).Scan(&id)
+	if err != nil {
+		return userWriteError(err)
+	}
+	zap.L().Info("user registered", zap.Int("user_id", id), zap.String("username", body.Username))
+	if email != nil {
+		if err := sendVerification(c.Context(), id, *email); err != nil {
+			zap.L().Error("verification email failed", zap.Int("user_id", id), zap.Error(err))
+		}
 	}
 	return c.SendStatus(fiber.StatusCreated)
 }
 
-// Login authenticates and returns JWT
+// Login authenticates and returns access and refresh tokens. Repeated
+// failures lock the account or client IP out for a while.
 func Login(c *fiber.Ctx) error {
 	type req struct {
 		Username string `json:"username"`
 		Password string `json:"password"`
 	}
 	var body req
-	if err := c.BodyParser(&body); err != nil {
+	if err := c.BodyParser(&body); err != nil || body.Username == "" || body.Password == "" {
 		return fiber.ErrBadRequest
 	}
+	ctx := c.Context()
+	body.Username = strings.TrimSpace(body.Username)
+	if wait := loginLockedFor(ctx, body.Username, c.IP()); wait > 0 {
+		secs := int(math.Ceil(wait.Seconds()))
+		c.Set(fiber.HeaderRetryAfter, strconv.Itoa(secs))
+		return fiber.NewError(fiber.StatusTooManyRequests,
+			fmt.Sprintf("too many failed logins; try again in %d seconds", secs))
+	}
+
 	var (
-		id      int
-		pwHash  string
-		isAdmin bool
+		id       int
+		pwHash   string
+		isAdmin  bool
+		version  int
+		disabled bool
 	)
-	row := db.PG.QueryRow(c.Context(),
-		"SELECT id,password,is_admin FROM users WHERE username=$1", body.Username)
-	if err := row.Scan(&id, &pwHash, &isAdmin); err != nil {
-		if errors.Is(err, pgx.ErrNoRows) {
-			fmt.Println(err)
-			return fiber.ErrUnauthorized
-		}
-		fmt.Println(err)
+	row := db.PG.QueryRow(ctx,
+		"SELECT id,COALESCE(password,''),is_admin,token_version,disabled_at IS NOT NULL FROM users WHERE username=$1", body.Username)
+	err := row.Scan(&id, &pwHash, &isAdmin, &version, &disabled)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		zap.L().Error("login lookup failed", zap.Error(err))
 		return fiber.ErrInternalServerError
 	}
-	if bcrypt.CompareHashAndPassword([]byte(pwHash), []byte(body.Password)) != nil {
+	// unknown and password-less (SSO) users still pay for a bcrypt compare
+	hash := []byte(pwHash)
+	if err != nil || pwHash == "" {
+		hash = dummyHash
+	}
+	matched := bcrypt.CompareHashAndPassword(hash, []byte(body.Password)) == nil
+	if err != nil || pwHash == "" || !matched {
+		recordLoginFailure(ctx, body.Username, c.IP())
+		zap.L().Info("login failed", zap.String("username", body.Username), zap.String("ip", c.IP()))
 		return fiber.ErrUnauthorized
 	}
-	// Create JWT
-	claims := jwt.MapClaims{
-		"sub":   id,
-		"admin": isAdmin,
-		"exp":   time.Now().Add(24 * time.Hour).Unix(),
+	clearLoginFailures(ctx, body.Username)
+	if disabled {
+		return errAccountDisabled
 	}
-	fmt.Println(claims)
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	secret := config.Load().JWTSecret
-	signed, err := token.SignedString([]byte(secret))
+
+	// Create access + refresh tokens
+	pair, err := issueTokens(ctx, id, isAdmin, version, "")
 	if err != nil {
-		fmt.Println(err)
+		zap.L().Error("issuing tokens failed", zap.Int("user_id", id), zap.Error(err))
 		return fiber.ErrInternalServerError
 	}
-	return c.JSON(fiber.Map{"token": signed})
+	return c.JSON(pair)
 }
 
-// Protect is middleware validating JWT; if requireAdmin, also checks "admin" claim.
+// Protect is middleware validating a JWT or API key bearer token; if
+// requireAdmin, also checks "admin" claim (API keys: admin user + admin capability).
 func Protect(requireAdmin bool) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		h := c.Get("Authorization")
@@ -88,17 +138,24 @@ func Protect(requireAdmin bool) fiber.Handler {
 			return fiber.ErrUnauthorized
 		}
 		tkn := h[7:]
-		token, err := jwt.Parse(tkn, func(t *jwt.Token) (interface{}, error) {
-			return []byte(config.Load().JWTSecret), nil
-		})
-		if err != nil || !token.Valid {
+		if strings.HasPrefix(tkn, APIKeyPrefix) {
+			return protectAPIKey(c, tkn, requireAdmin)
+		}
+		claims, err := ValidateAccessToken(c.Context(), tkn)
+		if err != nil {
 			return fiber.ErrUnauthorized
 		}
-		claims := token.Claims.(jwt.MapClaims)
-		if requireAdmin && claims["admin"] != true {
+		if requireAdmin && !claims.Admin {
 			return fiber.ErrForbidden
 		}
-		c.Locals("userID", int(claims["sub"].(float64)))
+		c.Locals("userID", claims.UserID)
+		c.Locals("isAdmin", claims.Admin)
+		setOrg(c, claims.OrgID, claims.OrgSlug, claims.OrgAdmin)
+		c.Locals("tokenJTI", claims.JTI)
+		// long-lived connections (WebSocket) close themselves at expiry
+		if !claims.Expires.IsZero() {
+			c.Locals("tokenExp", claims.Expires)
+		}
 		return c.Next()
 	}
 }
@@ -0,0 +1,348 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/zeelrupapara/custom-ai-server/pkg/config"
+	"github.com/zeelrupapara/custom-ai-server/pkg/db"
+	"github.com/zeelrupapara/custom-ai-server/pkg/mailer"
+)
+
+// user_tokens purposes
+const (
+	purposeVerifyEmail   = "verify_email"
+	purposeResetPassword = "reset_password"
+)
+
+// mailCooldown limits how often one account can be sent the same kind of email
+const mailCooldown = time.Minute
+
+var errTokenInvalid = fiber.NewError(fiber.StatusBadRequest, "invalid or expired token")
+
+func mailCooldownKey(purpose string, userID int) string {
+	return fmt.Sprintf("auth:mail-cooldown:%s:%d", purpose, userID)
+}
+
+// validateEmail returns a problem with email, or "" if it is fine
+func validateEmail(email string) string {
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email || len(email) > 254 {
+		return "must be a plain email address"
+	}
+	return ""
+}
+
+// issueUserToken stores a new single-use token for userID and returns it
+func issueUserToken(ctx context.Context, userID int, purpose, email string, ttl time.Duration) (string, error) {
+	tkn := randomHex(32)
+	_, err := db.PG.Exec(ctx,
+		`INSERT INTO user_tokens(user_id,purpose,token_hash,email,expires_at) VALUES($1,$2,$3,$4,$5)`,
+		userID, purpose, hashToken(tkn), email, time.Now().Add(ttl))
+	return tkn, err
+}
+
+// consumeUserToken marks an unexpired, unused token as used within tx and
+// returns the user and email it was issued for.
+func consumeUserToken(ctx context.Context, tx pgx.Tx, tkn, purpose string) (userID int, email string, err error) {
+	err = tx.QueryRow(ctx,
+		`UPDATE user_tokens SET used_at=NOW()
+		 WHERE token_hash=$1 AND purpose=$2 AND used_at IS NULL AND expires_at > NOW()
+		 RETURNING user_id, email`, hashToken(tkn), purpose).Scan(&userID, &email)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, "", errTokenInvalid
+	}
+	return userID, email, err
+}
+
+// sendVerification mails userID a link confirming email
+func sendVerification(ctx context.Context, userID int, email string) error {
+	cfg := config.Load()
+	tkn, err := issueUserToken(ctx, userID, purposeVerifyEmail, email, cfg.EmailVerifyTTL)
+	if err != nil {
+		return err
+	}
+	return mailer.Send(ctx, mailer.Message{
+		To:      email,
+		Subject: "Confirm your email address",
+		Body: fmt.Sprintf("Open this link to confirm your email address:\n\n%s/email/verify?token=%s\n\n"+
+			"The link expires in %s. If you did not ask for this, ignore this email.\n",
+			cfg.AppBaseURL, tkn, cfg.EmailVerifyTTL),
+	})
+}
+
+// RequestEmailVerification handles POST /email/verification. It sets the
+// caller's email when the body has one, then mails a verification link.
+func RequestEmailVerification(c *fiber.Ctx) error {
+	if err := sessionOnly(c); err != nil {
+		return err
+	}
+	type req struct {
+		Email string `json:"email"`
+	}
+	var body req
+	c.BodyParser(&body)
+	ctx := c.Context()
+	userID := c.Locals("userID").(int)
+
+	if body.Email = strings.TrimSpace(body.Email); body.Email != "" {
+		if msg := validateEmail(body.Email); msg != "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid email", "fields": fiber.Map{"email": msg}})
+		}
+		_, err := db.PG.Exec(ctx,
+			`UPDATE users SET email=$1, email_verified_at=NULL
+			 WHERE id=$2 AND email IS DISTINCT FROM $1`, body.Email, userID)
+		if err != nil {
+			return userWriteError(err)
+		}
+	}
+
+	var (
+		email    *string
+		verified *time.Time
+	)
+	err := db.PG.QueryRow(ctx, `SELECT email, email_verified_at FROM users WHERE id=$1`, userID).Scan(&email, &verified)
+	switch {
+	case err != nil:
+		return fiber.ErrInternalServerError
+	case email == nil:
+		return fiber.NewError(fiber.StatusBadRequest, "no email address on file")
+	case verified != nil:
+		return fiber.NewError(fiber.StatusConflict, "email address is already verified")
+	}
+	if ok, err := db.RDB.SetNX(ctx, mailCooldownKey(purposeVerifyEmail, userID), 1, mailCooldown).Result(); err == nil && !ok {
+		return fiber.NewError(fiber.StatusTooManyRequests, "a verification email was sent recently")
+	}
+	if err := sendVerification(ctx, userID, *email); err != nil {
+		zap.L().Error("verification email failed", zap.Int("user_id", userID), zap.Error(err))
+		return fiber.NewError(fiber.StatusBadGateway, "could not send email")
+	}
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{"email": *email})
+}
+
+// VerifyEmail handles GET and POST /email/verify with the mailed token
+func VerifyEmail(c *fiber.Ctx) error {
+	tkn := c.Query("token")
+	if tkn == "" {
+		type req struct {
+			Token string `json:"token"`
+		}
+		var body req
+		c.BodyParser(&body)
+		tkn = body.Token
+	}
+	if tkn == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "token is required")
+	}
+	ctx := c.Context()
+	tx, err := db.PG.Begin(ctx)
+	if err != nil {
+		return fiber.ErrInternalServerError
+	}
+	defer tx.Rollback(ctx)
+	userID, email, err := consumeUserToken(ctx, tx, tkn, purposeVerifyEmail)
+	if err != nil {
+		return tokenError(err)
+	}
+	tag, err := tx.Exec(ctx,
+		`UPDATE users SET email_verified_at=NOW() WHERE id=$1 AND LOWER(email)=LOWER($2)`, userID, email)
+	if err != nil {
+		return fiber.ErrInternalServerError
+	}
+	if tag.RowsAffected() == 0 {
+		return fiber.NewError(fiber.StatusBadRequest, "the email address changed since this link was sent")
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fiber.ErrInternalServerError
+	}
+	return c.JSON(fiber.Map{"email": email, "verified": true})
+}
+
+// ForgotPassword handles POST /password/forgot with an email or username.
+// It always answers 202 so it can't be used to find accounts; the reset
+// link only goes to a verified address of a password account.
+func ForgotPassword(c *fiber.Ctx) error {
+	type req struct {
+		Email    string `json:"email"`
+		Username string `json:"username"`
+	}
+	var body req
+	if err := c.BodyParser(&body); err != nil || (body.Email == "" && body.Username == "") {
+		return fiber.NewError(fiber.StatusBadRequest, "email or username is required")
+	}
+	// mail delivery time would tell whether the account exists
+	go sendPasswordReset(strings.TrimSpace(body.Email), strings.TrimSpace(body.Username))
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{"status": "if the account exists, a reset link has been sent"})
+}
+
+// sendPasswordReset mails a reset link to the matching account, if any
+func sendPasswordReset(email, username string) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	q := `SELECT id, email FROM users
+	      WHERE email_verified_at IS NOT NULL AND password IS NOT NULL AND `
+	arg := username
+	if email != "" {
+		q += `LOWER(email)=LOWER($1)`
+		arg = email
+	} else {
+		q += `username=$1`
+	}
+	var (
+		userID int
+		to     string
+	)
+	if err := db.PG.QueryRow(ctx, q, arg).Scan(&userID, &to); err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			zap.L().Error("password reset lookup failed", zap.Error(err))
+		}
+		return
+	}
+	if ok, err := db.RDB.SetNX(ctx, mailCooldownKey(purposeResetPassword, userID), 1, mailCooldown).Result(); err == nil && !ok {
+		return
+	}
+	if err := mailPasswordReset(ctx, userID, to); err != nil {
+		zap.L().Error("password reset email failed", zap.Int("user_id", userID), zap.Error(err))
+	}
+}
+
+// mailPasswordReset sends userID a reset link at address to
+func mailPasswordReset(ctx context.Context, userID int, to string) error {
+	cfg := config.Load()
+	tkn, err := issueUserToken(ctx, userID, purposeResetPassword, to, cfg.PasswordResetTTL)
+	if err != nil {
+		return err
+	}
+	return mailer.Send(ctx, mailer.Message{
+		To:      to,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Someone asked to reset the password for your account. Open this link to choose a new one:\n\n"+
+			"%s/password/reset?token=%s\n\nThe link expires in %s. If it wasn't you, ignore this email; "+
+			"your password is unchanged.\n", cfg.AppBaseURL, tkn, cfg.PasswordResetTTL),
+	})
+}
+
+// MailPasswordReset sends userID a reset link at their verified address
+func MailPasswordReset(ctx context.Context, userID int) error {
+	var to string
+	err := db.PG.QueryRow(ctx,
+		`SELECT email FROM users WHERE id=$1 AND email_verified_at IS NOT NULL`, userID).Scan(&to)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return fiber.NewError(fiber.StatusConflict, "user has no verified email address")
+	}
+	if err != nil {
+		return err
+	}
+	return mailPasswordReset(ctx, userID, to)
+}
+
+// SetPassword replaces userID's password, which must meet the policy, and
+// revokes their sessions. Policy violations are 400 *fiber.Errors.
+func SetPassword(ctx context.Context, userID int, password string) error {
+	var username string
+	if err := db.PG.QueryRow(ctx, `SELECT username FROM users WHERE id=$1`, userID).Scan(&username); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return fiber.ErrNotFound
+		}
+		return err
+	}
+	if msg := validatePassword(password, username); msg != "" {
+		return fiber.NewError(fiber.StatusBadRequest, "password "+msg)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	_, err = db.PG.Exec(ctx,
+		`UPDATE users SET password=$1, token_version=token_version+1 WHERE id=$2`, string(hash), userID)
+	if err == nil {
+		clearLoginFailures(ctx, username)
+	}
+	return err
+}
+
+// ResetPassword handles POST /password/reset {"token","password"}. The new
+// password must meet the policy; every existing session is revoked.
+func ResetPassword(c *fiber.Ctx) error {
+	type req struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+	var body req
+	if err := c.BodyParser(&body); err != nil || body.Token == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "token and password are required")
+	}
+	ctx := c.Context()
+	tx, err := db.PG.Begin(ctx)
+	if err != nil {
+		return fiber.ErrInternalServerError
+	}
+	// a rejected password rolls back, leaving the token usable
+	defer tx.Rollback(ctx)
+	userID, _, err := consumeUserToken(ctx, tx, body.Token, purposeResetPassword)
+	if err != nil {
+		return tokenError(err)
+	}
+	var username string
+	if err := tx.QueryRow(ctx, `SELECT username FROM users WHERE id=$1 FOR UPDATE`, userID).Scan(&username); err != nil {
+		return fiber.ErrInternalServerError
+	}
+	if msg := validatePassword(body.Password, username); msg != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid password", "fields": fiber.Map{"password": msg}})
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(body.Password), bcrypt.DefaultCost)
+	if err != nil {
+		return fiber.ErrInternalServerError
+	}
+	_, err = tx.Exec(ctx,
+		`UPDATE users SET password=$1, token_version=token_version+1 WHERE id=$2`, string(hash), userID)
+	if err != nil {
+		return fiber.ErrInternalServerError
+	}
+	// other outstanding reset links die with this one
+	_, err = tx.Exec(ctx,
+		`UPDATE user_tokens SET used_at=NOW() WHERE user_id=$1 AND purpose=$2 AND used_at IS NULL`,
+		userID, purposeResetPassword)
+	if err != nil {
+		return fiber.ErrInternalServerError
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fiber.ErrInternalServerError
+	}
+	clearLoginFailures(ctx, username)
+	zap.L().Info("password reset", zap.Int("user_id", userID))
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// tokenError passes errTokenInvalid through and hides anything else
+func tokenError(err error) error {
+	if errors.Is(err, errTokenInvalid) {
+		return err
+	}
+	zap.L().Error("user token lookup failed", zap.Error(err))
+	return fiber.ErrInternalServerError
+}
+
+// userWriteError maps unique violations on users to 409s
+func userWriteError(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" { // unique_violation
+		if pgErr.ConstraintName == "users_email_idx" {
+			return fiber.NewError(fiber.StatusConflict, "email address is already registered")
+		}
+		return fiber.NewError(fiber.StatusConflict, "username is taken")
+	}
+	zap.L().Error("user write failed", zap.Error(err))
+	return fiber.ErrInternalServerError
+}
@@ -0,0 +1,338 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5"
+	redis "github.com/redis/go-redis/v9"
+	"golang.org/x/oauth2"
+
+	"github.com/zeelrupapara/custom-ai-server/pkg/config"
+	"github.com/zeelrupapara/custom-ai-server/pkg/db"
+)
+
+// oidcStateTTL bounds how long a user may take at the identity provider
+const oidcStateTTL = 10 * time.Minute
+
+var errOIDCDisabled = fiber.NewError(fiber.StatusNotFound, "single sign-on is not configured")
+
+// oidcHTTP is used for discovery, key sets and the code exchange
+var oidcHTTP = &http.Client{Timeout: 10 * time.Second}
+
+// oidcClient is the discovered identity provider and our client for it
+type oidcClient struct {
+	provider *oidc.Provider
+	verifier *oidc.IDTokenVerifier
+	oauth    oauth2.Config
+}
+
+var (
+	oidcMu  sync.Mutex
+	oidcCli *oidcClient
+)
+
+// oidcLogin is what OIDCLogin stores under the state parameter
+type oidcLogin struct {
+	Verifier string `json:"verifier"`
+	Nonce    string `json:"nonce"`
+}
+
+func oidcStateKey(state string) string { return "auth:oidc-state:" + state }
+
+// getOIDC runs discovery on first use, so the server starts even when the
+// identity provider is down. A failed discovery is retried next time.
+func getOIDC() (*oidcClient, error) {
+	oidcMu.Lock()
+	defer oidcMu.Unlock()
+	if oidcCli != nil {
+		return oidcCli, nil
+	}
+	cfg := config.Load()
+	if cfg.OIDCIssuer == "" {
+		return nil, errOIDCDisabled
+	}
+	// the provider fetches signing keys with this context for its lifetime
+	ctx := oidc.ClientContext(context.Background(), oidcHTTP)
+	provider, err := oidc.NewProvider(ctx, cfg.OIDCIssuer)
+	if err != nil {
+		return nil, fmt.Errorf("oidc discovery: %w", err)
+	}
+	oidcCli = &oidcClient{
+		provider: provider,
+		verifier: provider.Verifier(&oidc.Config{ClientID: cfg.OIDCClientID}),
+		oauth: oauth2.Config{
+			ClientID:     cfg.OIDCClientID,
+			ClientSecret: cfg.OIDCClientSecret,
+			RedirectURL:  cfg.OIDCRedirectURL,
+			Endpoint:     provider.Endpoint(),
+			Scopes:       strings.Fields(cfg.OIDCScopes),
+		},
+	}
+	return oidcCli, nil
+}
+
+// OIDCLogin starts the authorization-code flow by redirecting the browser
+// to the identity provider, with PKCE and a nonce bound to the state.
+func OIDCLogin(c *fiber.Ctx) error {
+	cli, err := getOIDC()
+	if err != nil {
+		return oidcFailure(err)
+	}
+	state := randomHex(16)
+	login := oidcLogin{Verifier: oauth2.GenerateVerifier(), Nonce: randomHex(16)}
+	raw, _ := json.Marshal(login)
+	if err := db.RDB.Set(c.Context(), oidcStateKey(state), raw, oidcStateTTL).Err(); err != nil {
+		return fiber.ErrInternalServerError
+	}
+	return c.Redirect(cli.oauth.AuthCodeURL(state,
+		oidc.Nonce(login.Nonce), oauth2.S256ChallengeOption(login.Verifier)), fiber.StatusFound)
+}
+
+// OIDCCallback completes the flow: it redeems the code, validates the ID
+// token, provisions the user on first login and issues our own tokens.
+func OIDCCallback(c *fiber.Ctx) error {
+	if e := c.Query("error"); e != "" {
+		return fiber.NewError(fiber.StatusUnauthorized, strings.TrimSpace(e+" "+c.Query("error_description")))
+	}
+	cli, err := getOIDC()
+	if err != nil {
+		return oidcFailure(err)
+	}
+	ctx := c.Context()
+	cfg := config.Load()
+
+	raw, err := db.RDB.GetDel(ctx, oidcStateKey(c.Query("state"))).Result()
+	if errors.Is(err, redis.Nil) {
+		return fiber.NewError(fiber.StatusBadRequest, "unknown or expired login state")
+	}
+	if err != nil {
+		return fiber.ErrInternalServerError
+	}
+	var login oidcLogin
+	if err := json.Unmarshal([]byte(raw), &login); err != nil {
+		return fiber.ErrInternalServerError
+	}
+
+	idt, claims, err := cli.redeem(ctx, c.Query("code"), login, cfg.OIDCGroupsClaim)
+	if err != nil {
+		return err
+	}
+
+	userID, isAdmin, version, err := provisionOIDCUser(ctx, idt.Issuer, idt.Subject, claims)
+	if errors.Is(err, errAccountDisabled) {
+		return err
+	}
+	if err != nil {
+		log.Printf("oidc provisioning %s/%s: %v", idt.Issuer, idt.Subject, err)
+		return fiber.ErrInternalServerError
+	}
+	pair, err := issueTokens(ctx, userID, isAdmin, version, "")
+	if err != nil {
+		return fiber.ErrInternalServerError
+	}
+	if cfg.OIDCPostLoginURL != "" {
+		// hand the tokens to a browser app in the fragment, which never reaches servers
+		frag := url.Values{
+			"access_token":  {pair.AccessToken},
+			"refresh_token": {pair.RefreshToken},
+			"token_type":    {pair.TokenType},
+			"expires_in":    {fmt.Sprint(pair.ExpiresIn)},
+		}
+		return c.Redirect(cfg.OIDCPostLoginURL+"#"+frag.Encode(), fiber.StatusFound)
+	}
+	return c.JSON(pair)
+}
+
+// redeem trades code for tokens with login's PKCE verifier and returns the
+// verified ID token and its claims, checking the nonce bound to the login.
+// Groups missing from the ID token are taken from userinfo.
+func (cli *oidcClient) redeem(ctx context.Context, code string, login oidcLogin, groupsClaim string) (*oidc.IDToken, map[string]any, error) {
+	hctx := context.WithValue(ctx, oauth2.HTTPClient, oidcHTTP)
+	tok, err := cli.oauth.Exchange(hctx, code, oauth2.VerifierOption(login.Verifier))
+	if err != nil {
+		log.Printf("oidc code exchange: %v", err)
+		return nil, nil, fiber.ErrUnauthorized
+	}
+	rawID, ok := tok.Extra("id_token").(string)
+	if !ok {
+		return nil, nil, fiber.NewError(fiber.StatusUnauthorized, "identity provider returned no ID token")
+	}
+	idt, err := cli.verifier.Verify(hctx, rawID)
+	if err != nil {
+		log.Printf("oidc id token: %v", err)
+		return nil, nil, fiber.ErrUnauthorized
+	}
+	if idt.Nonce != login.Nonce {
+		return nil, nil, fiber.ErrUnauthorized
+	}
+
+	var claims map[string]any
+	if err := idt.Claims(&claims); err != nil {
+		return nil, nil, fiber.ErrUnauthorized
+	}
+	// some providers only put groups in userinfo
+	if _, ok := claims[groupsClaim]; !ok {
+		if info, err := cli.provider.UserInfo(hctx, oauth2.StaticTokenSource(tok)); err == nil {
+			var extra map[string]any
+			if info.Claims(&extra) == nil {
+				if g, ok := extra[groupsClaim]; ok {
+					claims[groupsClaim] = g
+				}
+			}
+		}
+	}
+	return idt, claims, nil
+}
+
+// oidcFailure hides discovery errors from clients
+func oidcFailure(err error) error {
+	if errors.Is(err, errOIDCDisabled) {
+		return err
+	}
+	log.Printf("%v", err)
+	return fiber.NewError(fiber.StatusBadGateway, "identity provider unavailable")
+}
+
+// provisionOIDCUser finds the user linked to (issuer, subject), creating
+// one on first login, in a single transaction.
+func provisionOIDCUser(ctx context.Context, issuer, subject string, claims map[string]any) (userID int, isAdmin bool, version int, err error) {
+	tx, err := db.PG.Begin(ctx)
+	if err != nil {
+		return 0, false, 0, err
+	}
+	defer tx.Rollback(ctx)
+	if userID, isAdmin, version, err = linkOIDCUser(ctx, tx, issuer, subject, claims); err != nil {
+		return 0, false, 0, err
+	}
+	return userID, isAdmin, version, tx.Commit(ctx)
+}
+
+// linkOIDCUser does the work of provisionOIDCUser in tx. When
+// OIDC_ADMIN_GROUPS is set the provider's groups decide is_admin on every
+// login; otherwise is_admin is left alone. Groups linked to a provider group
+// are synced too.
+func linkOIDCUser(ctx context.Context, tx pgx.Tx, issuer, subject string, claims map[string]any) (userID int, isAdmin bool, version int, err error) {
+	cfg := config.Load()
+	email, _ := claims["email"].(string)
+	displayName, _ := claims["name"].(string)
+	adminGroups := splitList(cfg.OIDCAdminGroups)
+	mapAdmin := len(adminGroups) > 0
+	groups := claimStrings(claims[cfg.OIDCGroupsClaim])
+	wantAdmin := false
+	for _, g := range groups {
+		if slices.Contains(adminGroups, g) {
+			wantAdmin = true
+		}
+	}
+
+	var disabled bool
+	err = tx.QueryRow(ctx,
+		`SELECT u.id, u.is_admin, u.token_version, u.disabled_at IS NOT NULL FROM user_identities i
+		 JOIN users u ON u.id = i.user_id WHERE i.issuer=$1 AND i.subject=$2`, issuer, subject).
+		Scan(&userID, &isAdmin, &version, &disabled)
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		isAdmin = mapAdmin && wantAdmin
+		if userID, err = createOIDCUser(ctx, tx, subject, claims, displayName, isAdmin); err != nil {
+			return 0, false, 0, err
+		}
+		_, err = tx.Exec(ctx,
+			`INSERT INTO user_identities(user_id,issuer,subject,email) VALUES($1,$2,$3,$4)`,
+			userID, issuer, subject, email)
+	case err != nil:
+		return 0, false, 0, err
+	case disabled:
+		return 0, false, 0, errAccountDisabled
+	default:
+		if mapAdmin && isAdmin != wantAdmin {
+			// older tokens still carry the old admin claim; revoke them
+			isAdmin = wantAdmin
+			err = tx.QueryRow(ctx,
+				`UPDATE users SET is_admin=$1, token_version=token_version+1 WHERE id=$2 RETURNING token_version`,
+				isAdmin, userID).Scan(&version)
+			if err != nil {
+				return 0, false, 0, err
+			}
+		}
+		_, err = tx.Exec(ctx,
+			`UPDATE user_identities SET email=$1, last_login_at=NOW() WHERE issuer=$2 AND subject=$3`,
+			email, issuer, subject)
+		if err == nil {
+			_, err = tx.Exec(ctx,
+				`UPDATE users SET display_name=COALESCE(NULLIF($1,''),display_name) WHERE id=$2`, displayName, userID)
+		}
+	}
+	if err == nil {
+		err = syncOIDCGroups(ctx, tx, userID, groups)
+	}
+	if err != nil {
+		return 0, false, 0, err
+	}
+	return userID, isAdmin, version, nil
+}
+
+// createOIDCUser inserts a password-less user named after the provider's
+// preferred username or email, suffixed when that name is already taken.
+func createOIDCUser(ctx context.Context, tx pgx.Tx, subject string, claims map[string]any, displayName string, isAdmin bool) (int, error) {
+	name, _ := claims["preferred_username"].(string)
+	if name == "" {
+		name, _ = claims["email"].(string)
+	}
+	if name == "" {
+		name = subject
+	}
+	sum := sha256.Sum256([]byte(subject))
+	for _, username := range []string{name, name + "-" + hex.EncodeToString(sum[:3])} {
+		var id int
+		err := tx.QueryRow(ctx,
+			`INSERT INTO users(username,password,is_admin,display_name) VALUES($1,NULL,$2,NULLIF($3,''))
+			 ON CONFLICT (username) DO NOTHING RETURNING id`, username, isAdmin, displayName).Scan(&id)
+		if errors.Is(err, pgx.ErrNoRows) {
+			continue
+		}
+		return id, err
+	}
+	return 0, fmt.Errorf("username %q is taken", name)
+}
+
+// claimStrings reads a claim holding a string or a list of strings
+func claimStrings(v any) []string {
+	switch v := v.(type) {
+	case string:
+		return []string{v}
+	case []any:
+		out := make([]string, 0, len(v))
+		for _, e := range v {
+			if s, ok := e.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+// splitList splits a comma separated setting, dropping blanks
+func splitList(s string) []string {
+	var out []string
+	for _, p := range strings.Split(s, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
@@ -0,0 +1,565 @@
+package auth
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	redis "github.com/redis/go-redis/v9"
+	"golang.org/x/oauth2"
+
+	"github.com/zeelrupapara/custom-ai-server/pkg/db"
+)
+
+const testClientID = "client-1"
+
+// fakeIdP is an OpenID provider serving discovery, a key set, a token
+// endpoint that checks PKCE and userinfo.
+type fakeIdP struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	mu       sync.Mutex
+	grants   map[string]idpGrant
+	userinfo map[string]any
+}
+
+// idpGrant is what an authorization code was issued for
+type idpGrant struct {
+	challenge, nonce string
+	claims           map[string]any
+}
+
+func newFakeIdP(t *testing.T) *fakeIdP {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &fakeIdP{key: key, grants: map[string]idpGrant{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]any{
+			"issuer":                                p.URL,
+			"authorization_endpoint":                p.URL + "/authorize",
+			"token_endpoint":                        p.URL + "/token",
+			"jwks_uri":                              p.URL + "/jwks",
+			"userinfo_endpoint":                     p.URL + "/userinfo",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]any{"keys": []map[string]string{{
+			"kty": "RSA", "kid": "k1", "alg": "RS256", "use": "sig",
+			"n": base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", p.token)
+	mux.HandleFunc("/userinfo", func(w http.ResponseWriter, r *http.Request) {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		writeJSON(w, p.userinfo)
+	})
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Close)
+	return p
+}
+
+// grant issues a code for an ID token with nonce and claims, redeemable
+// only with the verifier behind challenge.
+func (p *fakeIdP) grant(challenge, nonce string, claims map[string]any) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	code := "code-" + strconv.Itoa(len(p.grants)+1)
+	p.grants[code] = idpGrant{challenge: challenge, nonce: nonce, claims: claims}
+	return code
+}
+
+func (p *fakeIdP) token(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	p.mu.Lock()
+	g, ok := p.grants[r.Form.Get("code")]
+	p.mu.Unlock()
+	sum := sha256.Sum256([]byte(r.Form.Get("code_verifier")))
+	if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != g.challenge {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		io.WriteString(w, `{"error":"invalid_grant"}`)
+		return
+	}
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss": p.URL, "aud": testClientID, "sub": "sub-1", "nonce": g.nonce,
+		"iat": now.Unix(), "exp": now.Add(time.Hour).Unix(),
+	}
+	for k, v := range g.claims {
+		claims[k] = v
+	}
+	tok := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	tok.Header["kid"] = "k1"
+	idToken, err := tok.SignedString(p.key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, map[string]any{"access_token": "at", "token_type": "Bearer", "expires_in": 3600, "id_token": idToken})
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// fakeRedis speaks just enough RESP for the login state: SET, GET and GETDEL.
+type fakeRedis struct {
+	mu   sync.Mutex
+	data map[string]string
+}
+
+func newFakeRedis(t *testing.T) (*fakeRedis, *redis.Client) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &fakeRedis{data: map[string]string{}}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go r.serve(conn)
+		}
+	}()
+	client := redis.NewClient(&redis.Options{Addr: ln.Addr().String(), Protocol: 2, DisableIdentity: true})
+	t.Cleanup(func() {
+		client.Close()
+		ln.Close()
+	})
+	return r, client
+}
+
+func (r *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	br := bufio.NewReader(conn)
+	for {
+		args, err := readCommand(br)
+		if err != nil {
+			return
+		}
+		r.mu.Lock()
+		var reply string
+		switch strings.ToUpper(args[0]) {
+		case "SET":
+			r.data[args[1]] = args[2]
+			reply = "+OK\r\n"
+		case "GET", "GETDEL":
+			v, ok := r.data[args[1]]
+			if strings.EqualFold(args[0], "GETDEL") {
+				delete(r.data, args[1])
+			}
+			reply = "$-1\r\n"
+			if ok {
+				reply = fmt.Sprintf("$%d\r\n%s\r\n", len(v), v)
+			}
+		default:
+			reply = "-ERR unknown command '" + args[0] + "'\r\n"
+		}
+		r.mu.Unlock()
+		if _, err := io.WriteString(conn, reply); err != nil {
+			return
+		}
+	}
+}
+
+// readCommand reads one RESP array of bulk strings
+func readCommand(br *bufio.Reader) ([]string, error) {
+	line, err := br.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "*")))
+	if err != nil || n < 1 {
+		return nil, fmt.Errorf("bad command %q", line)
+	}
+	args := make([]string, n)
+	for i := range args {
+		if line, err = br.ReadString('\n'); err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "$")))
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(br, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+	return args, nil
+}
+
+func (r *fakeRedis) get(key string) (string, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	v, ok := r.data[key]
+	return v, ok
+}
+
+// setupOIDC points the package at a fake provider and Redis and returns an
+// app serving the login and callback routes.
+func setupOIDC(t *testing.T) (*fakeIdP, *fakeRedis, *fiber.App) {
+	t.Helper()
+	idp := newFakeIdP(t)
+	t.Setenv("OIDC_ISSUER", idp.URL)
+	t.Setenv("OIDC_CLIENT_ID", testClientID)
+	t.Setenv("OIDC_CLIENT_SECRET", "secret")
+	t.Setenv("OIDC_REDIRECT_URL", "http://app.test/auth/oidc/callback")
+	oidcCli = nil
+	t.Cleanup(func() { oidcCli = nil })
+
+	store, client := newFakeRedis(t)
+	prev := db.RDB
+	db.RDB = client
+	t.Cleanup(func() { db.RDB = prev })
+
+	app := fiber.New()
+	app.Get("/login", OIDCLogin)
+	app.Get("/callback", OIDCCallback)
+	return idp, store, app
+}
+
+// startLogin runs OIDCLogin and returns the query of the redirect to the
+// provider along with the login it stored.
+func startLogin(t *testing.T, app *fiber.App, store *fakeRedis) (url.Values, oidcLogin) {
+	t.Helper()
+	resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/login", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != fiber.StatusFound {
+		t.Fatalf("login status = %d, want 302", resp.StatusCode)
+	}
+	loc, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := loc.Query()
+	raw, ok := store.get(oidcStateKey(q.Get("state")))
+	if !ok {
+		t.Fatalf("no login stored for state %q", q.Get("state"))
+	}
+	var login oidcLogin
+	if err := json.Unmarshal([]byte(raw), &login); err != nil {
+		t.Fatal(err)
+	}
+	return q, login
+}
+
+func callback(t *testing.T, app *fiber.App, q url.Values) int {
+	t.Helper()
+	resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/callback?"+q.Encode(), nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode
+}
+
+func TestOIDCLogin(t *testing.T) {
+	_, store, app := setupOIDC(t)
+	q, login := startLogin(t, app, store)
+
+	if got := q.Get("client_id"); got != testClientID {
+		t.Errorf("client_id = %q, want %q", got, testClientID)
+	}
+	if got := q.Get("code_challenge_method"); got != "S256" {
+		t.Errorf("code_challenge_method = %q, want S256", got)
+	}
+	if got, want := q.Get("code_challenge"), oauth2.S256ChallengeFromVerifier(login.Verifier); got != want {
+		t.Errorf("code_challenge = %q, want the stored verifier's %q", got, want)
+	}
+	if q.Get("nonce") == "" || q.Get("nonce") != login.Nonce {
+		t.Errorf("nonce = %q, want the stored %q", q.Get("nonce"), login.Nonce)
+	}
+}
+
+func TestOIDCCallbackRejects(t *testing.T) {
+	idp, store, app := setupOIDC(t)
+
+	t.Run("provider error", func(t *testing.T) {
+		if got := callback(t, app, url.Values{"error": {"access_denied"}}); got != fiber.StatusUnauthorized {
+			t.Errorf("status = %d, want 401", got)
+		}
+	})
+	t.Run("unknown state", func(t *testing.T) {
+		if got := callback(t, app, url.Values{"state": {"nope"}, "code": {"code-0"}}); got != fiber.StatusBadRequest {
+			t.Errorf("status = %d, want 400", got)
+		}
+	})
+	t.Run("wrong PKCE verifier", func(t *testing.T) {
+		q, login := startLogin(t, app, store)
+		other := oauth2.S256ChallengeFromVerifier(oauth2.GenerateVerifier())
+		code := idp.grant(other, login.Nonce, nil)
+		if got := callback(t, app, url.Values{"state": {q.Get("state")}, "code": {code}}); got != fiber.StatusUnauthorized {
+			t.Errorf("status = %d, want 401", got)
+		}
+	})
+	t.Run("nonce mismatch", func(t *testing.T) {
+		q, login := startLogin(t, app, store)
+		code := idp.grant(q.Get("code_challenge"), login.Nonce+"-other", nil)
+		if got := callback(t, app, url.Values{"state": {q.Get("state")}, "code": {code}}); got != fiber.StatusUnauthorized {
+			t.Errorf("status = %d, want 401", got)
+		}
+	})
+	t.Run("state used twice", func(t *testing.T) {
+		q, login := startLogin(t, app, store)
+		code := idp.grant(q.Get("code_challenge"), login.Nonce+"-other", nil)
+		state := url.Values{"state": {q.Get("state")}, "code": {code}}
+		callback(t, app, state)
+		if got := callback(t, app, state); got != fiber.StatusBadRequest {
+			t.Errorf("replayed state: status = %d, want 400", got)
+		}
+	})
+}
+
+func TestOIDCRedeem(t *testing.T) {
+	idp, _, _ := setupOIDC(t)
+	cli, err := getOIDC()
+	if err != nil {
+		t.Fatal(err)
+	}
+	login := oidcLogin{Verifier: oauth2.GenerateVerifier(), Nonce: "n-1"}
+	challenge := oauth2.S256ChallengeFromVerifier(login.Verifier)
+
+	code := idp.grant(challenge, login.Nonce, map[string]any{"sub": "u-42", "groups": []string{"staff"}})
+	idt, claims, err := cli.redeem(context.Background(), code, login, "groups")
+	if err != nil {
+		t.Fatalf("redeem: %v", err)
+	}
+	if idt.Issuer != idp.URL || idt.Subject != "u-42" {
+		t.Errorf("token = %s/%s, want %s/u-42", idt.Issuer, idt.Subject, idp.URL)
+	}
+	if got := claimStrings(claims["groups"]); !reflect.DeepEqual(got, []string{"staff"}) {
+		t.Errorf("groups = %v, want [staff]", got)
+	}
+
+	// groups missing from the ID token come from userinfo
+	idp.mu.Lock()
+	idp.userinfo = map[string]any{"sub": "u-42", "groups": []string{"ops"}}
+	idp.mu.Unlock()
+	code = idp.grant(challenge, login.Nonce, map[string]any{"sub": "u-42"})
+	if _, claims, err = cli.redeem(context.Background(), code, login, "groups"); err != nil {
+		t.Fatalf("redeem: %v", err)
+	}
+	if got := claimStrings(claims["groups"]); !reflect.DeepEqual(got, []string{"ops"}) {
+		t.Errorf("groups from userinfo = %v, want [ops]", got)
+	}
+}
+
+// fakeDB holds the rows linkOIDCUser reads and writes
+type fakeDB struct {
+	users      map[int]*fakeUser
+	identities map[string]int  // "issuer subject" → user id
+	groups     map[string]int  // oidc_group → group id
+	members    map[[2]int]bool // {group id, user id}
+}
+
+type fakeUser struct {
+	name, display   string
+	admin, disabled bool
+	version         int
+}
+
+func newFakeDB() *fakeDB {
+	return &fakeDB{users: map[int]*fakeUser{}, identities: map[string]int{}, groups: map[string]int{}, members: map[[2]int]bool{}}
+}
+
+// fakeTx answers the statements of linkOIDCUser and syncOIDCGroups from a
+// fakeDB; any other method panics through the nil embedded Tx.
+type fakeTx struct {
+	pgx.Tx
+	db *fakeDB
+}
+
+type fakeRow struct {
+	vals []any
+	err  error
+}
+
+func (r fakeRow) Scan(dest ...any) error {
+	if r.err != nil {
+		return r.err
+	}
+	for i, d := range dest {
+		switch d := d.(type) {
+		case *int:
+			*d = r.vals[i].(int)
+		case *bool:
+			*d = r.vals[i].(bool)
+		}
+	}
+	return nil
+}
+
+func (tx fakeTx) QueryRow(_ context.Context, sql string, args ...any) pgx.Row {
+	d := tx.db
+	switch {
+	case strings.Contains(sql, "FROM user_identities"):
+		id, ok := d.identities[args[0].(string)+" "+args[1].(string)]
+		if !ok {
+			return fakeRow{err: pgx.ErrNoRows}
+		}
+		u := d.users[id]
+		return fakeRow{vals: []any{id, u.admin, u.version, u.disabled}}
+	case strings.Contains(sql, "INSERT INTO users"):
+		name := args[0].(string)
+		for _, u := range d.users {
+			if u.name == name {
+				return fakeRow{err: pgx.ErrNoRows}
+			}
+		}
+		id := len(d.users) + 1
+		d.users[id] = &fakeUser{name: name, admin: args[1].(bool), display: args[2].(string)}
+		return fakeRow{vals: []any{id}}
+	case strings.Contains(sql, "UPDATE users SET is_admin"):
+		u := d.users[args[1].(int)]
+		u.admin = args[0].(bool)
+		u.version++
+		return fakeRow{vals: []any{u.version}}
+	}
+	return fakeRow{err: fmt.Errorf("unexpected query %q", sql)}
+}
+
+func (tx fakeTx) Exec(_ context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	d := tx.db
+	switch {
+	case strings.Contains(sql, "INSERT INTO user_identities"):
+		d.identities[args[1].(string)+" "+args[2].(string)] = args[0].(int)
+	case strings.Contains(sql, "UPDATE user_identities"):
+	case strings.Contains(sql, "UPDATE users SET display_name"):
+		if name := args[0].(string); name != "" {
+			d.users[args[1].(int)].display = name
+		}
+	case strings.Contains(sql, "DELETE FROM group_members"):
+		for oidc, g := range d.groups {
+			if !slices.Contains(args[1].([]string), oidc) {
+				delete(d.members, [2]int{g, args[0].(int)})
+			}
+		}
+	case strings.Contains(sql, "INSERT INTO group_members"):
+		for _, oidc := range args[1].([]string) {
+			if g, ok := d.groups[oidc]; ok {
+				d.members[[2]int{g, args[0].(int)}] = true
+			}
+		}
+	default:
+		return pgconn.CommandTag{}, fmt.Errorf("unexpected statement %q", sql)
+	}
+	return pgconn.CommandTag{}, nil
+}
+
+func groupClaims(groups ...string) map[string]any {
+	list := make([]any, len(groups))
+	for i, g := range groups {
+		list[i] = g
+	}
+	return map[string]any{"preferred_username": "alice", "email": "alice@example.com", "name": "Alice", "groups": list}
+}
+
+func TestLinkOIDCUser(t *testing.T) {
+	t.Setenv("OIDC_ADMIN_GROUPS", "admins, root")
+	ctx := context.Background()
+	d := newFakeDB()
+	d.users[1] = &fakeUser{name: "alice"} // a local account already has the name
+	d.groups["staff"] = 7
+	tx := fakeTx{db: d}
+
+	// first login creates and links a user, admin by group
+	id, admin, version, err := linkOIDCUser(ctx, tx, "iss", "s-1", groupClaims("admins", "staff"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != 2 || !admin || version != 0 {
+		t.Fatalf("first login = (%d, %v, %d), want (2, true, 0)", id, admin, version)
+	}
+	if u := d.users[2]; !strings.HasPrefix(u.name, "alice-") || u.display != "Alice" || !u.admin {
+		t.Errorf("created user = %+v, want a suffixed alice, display Alice, admin", *u)
+	}
+	if d.identities["iss s-1"] != 2 {
+		t.Errorf("identity not linked: %v", d.identities)
+	}
+	if !d.members[[2]int{7, 2}] {
+		t.Errorf("not added to the staff group: %v", d.members)
+	}
+
+	// the next login finds the same user and changes nothing
+	id, admin, version, err = linkOIDCUser(ctx, tx, "iss", "s-1", groupClaims("admins", "staff"))
+	if err != nil || id != 2 || !admin || version != 0 || len(d.users) != 2 {
+		t.Fatalf("second login = (%d, %v, %d, %v) with %d users, want (2, true, 0) and no new user", id, admin, version, err, len(d.users))
+	}
+
+	// leaving the admin group drops is_admin and revokes older tokens
+	id, admin, version, err = linkOIDCUser(ctx, tx, "iss", "s-1", groupClaims("staff"))
+	if err != nil || admin || version != 1 || d.users[id].admin {
+		t.Fatalf("after leaving admins = (%v, %d, %v), want (false, 1)", admin, version, err)
+	}
+
+	// leaving a linked group removes the membership
+	if _, _, _, err = linkOIDCUser(ctx, tx, "iss", "s-1", groupClaims()); err != nil {
+		t.Fatal(err)
+	}
+	if d.members[[2]int{7, 2}] {
+		t.Errorf("still in the staff group after leaving it")
+	}
+
+	// a disabled account can't log in
+	d.users[2].disabled = true
+	if _, _, _, err = linkOIDCUser(ctx, tx, "iss", "s-1", groupClaims()); !errors.Is(err, errAccountDisabled) {
+		t.Errorf("disabled account: err = %v, want errAccountDisabled", err)
+	}
+}
+
+func TestLinkOIDCUserWithoutAdminGroups(t *testing.T) {
+	t.Setenv("OIDC_ADMIN_GROUPS", "")
+	ctx := context.Background()
+	d := newFakeDB()
+	tx := fakeTx{db: d}
+
+	// with no admin groups configured, groups never grant admin
+	id, admin, _, err := linkOIDCUser(ctx, tx, "iss", "s-1", groupClaims("admins"))
+	if err != nil || admin {
+		t.Fatalf("new user = (%v, %v), want not admin", admin, err)
+	}
+	// and an admin granted locally keeps it
+	d.users[id].admin = true
+	_, admin, version, err := linkOIDCUser(ctx, tx, "iss", "s-1", groupClaims())
+	if err != nil || !admin || version != 0 {
+		t.Errorf("local admin = (%v, %d, %v), want (true, 0)", admin, version, err)
+	}
+}
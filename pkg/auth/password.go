@@ -0,0 +1,67 @@
+package auth
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/zeelrupapara/custom-ai-server/pkg/config"
+)
+
+// bcrypt ignores everything past 72 bytes
+const maxPasswordBytes = 72
+
+var usernamePattern = regexp.MustCompile(`^[A-Za-z0-9._@-]{3,64}$`)
+
+// dummyHash is compared against when a login names no password user, so
+// unknown usernames cost as much time as wrong passwords.
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("not-a-real-password"), bcrypt.DefaultCost)
+
+// validateUsername returns a problem with username, or "" if it is fine
+func validateUsername(username string) string {
+	if !usernamePattern.MatchString(username) {
+		return "must be 3-64 characters of letters, digits, '.', '_', '@' or '-'"
+	}
+	return ""
+}
+
+// validatePassword checks password against the configured policy and
+// returns the first problem found, or "" if it is acceptable.
+func validatePassword(password, username string) string {
+	cfg := config.Load()
+	if len([]rune(password)) < cfg.PasswordMinLength {
+		return fmt.Sprintf("must be at least %d characters", cfg.PasswordMinLength)
+	}
+	if len(password) > maxPasswordBytes {
+		return fmt.Sprintf("must be at most %d bytes", maxPasswordBytes)
+	}
+	var lower, upper, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			symbol = true
+		}
+	}
+	classes := 0
+	for _, ok := range []bool{lower, upper, digit, symbol} {
+		if ok {
+			classes++
+		}
+	}
+	if classes < cfg.PasswordMinClasses {
+		return fmt.Sprintf("must mix at least %d of lowercase, uppercase, digits and symbols", cfg.PasswordMinClasses)
+	}
+	if username != "" && strings.Contains(strings.ToLower(password), strings.ToLower(username)) {
+		return "must not contain the username"
+	}
+	return ""
+}
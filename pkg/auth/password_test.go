@@ -0,0 +1,58 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidatePassword(t *testing.T) {
+	t.Setenv("PASSWORD_MIN_LENGTH", "12")
+	t.Setenv("PASSWORD_MIN_CLASSES", "3")
+	tests := []struct {
+		password, username string
+		want               string // substring of the problem, "" if accepted
+	}{
+		{"Correct-horse-7", "alice", ""},
+		{"correcthorse7!", "alice", ""}, // lower, digit, symbol
+		{"Short-1", "alice", "at least 12 characters"},
+		{"ÄäÖöÜüßẞ-1aB", "alice", ""}, // counted in characters, not bytes
+		{"correcthorsebattery", "alice", "at least 3 of"},
+		{"CorrectHorseBattery", "alice", "at least 3 of"},
+		{"Alice-horse-battery-7", "alice", "username"},
+		{"xx-ALICE-horse-7", "Alice", "username"},
+		{"Correct-horse-7" + strings.Repeat("a", 60), "bob", "at most 72 bytes"},
+	}
+	for _, tt := range tests {
+		got := validatePassword(tt.password, tt.username)
+		switch {
+		case tt.want == "" && got != "":
+			t.Errorf("validatePassword(%q, %q) = %q, want accepted", tt.password, tt.username, got)
+		case tt.want != "" && !strings.Contains(got, tt.want):
+			t.Errorf("validatePassword(%q, %q) = %q, want one containing %q", tt.password, tt.username, got, tt.want)
+		}
+	}
+}
+
+func TestValidatePasswordConfig(t *testing.T) {
+	t.Setenv("PASSWORD_MIN_LENGTH", "4")
+	t.Setenv("PASSWORD_MIN_CLASSES", "1")
+	if got := validatePassword("abcd", "bob"); got != "" {
+		t.Errorf("relaxed policy rejected %q: %s", "abcd", got)
+	}
+	if got := validatePassword("abc", "bob"); !strings.Contains(got, "at least 4") {
+		t.Errorf("validatePassword(%q) = %q", "abc", got)
+	}
+}
+
+func TestValidateUsername(t *testing.T) {
+	for _, name := range []string{"bob", "alice.smith", "a_b-c@example.com"} {
+		if got := validateUsername(name); got != "" {
+			t.Errorf("validateUsername(%q) = %q, want accepted", name, got)
+		}
+	}
+	for _, name := range []string{"", "ab", "has space", "semi;colon", strings.Repeat("x", 65)} {
+		if validateUsername(name) == "" {
+			t.Errorf("validateUsername(%q) accepted", name)
+		}
+	}
+}
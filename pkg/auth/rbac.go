@@ -0,0 +1,116 @@
+package auth
+
+import (
+	"context"
+	"log"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5"
+
+	"github.com/zeelrupapara/custom-ai-server/pkg/db"
+	"github.com/zeelrupapara/custom-ai-server/pkg/gpt"
+)
+
+// Roles returns the roles userID holds directly or through a group
+func Roles(ctx context.Context, userID int) ([]string, error) {
+	rows, err := db.PG.Query(ctx,
+		`SELECT r.name FROM roles r JOIN user_roles ur ON ur.role_id = r.id WHERE ur.user_id=$1
+		 UNION
+		 SELECT r.name FROM roles r
+		 JOIN group_roles gr ON gr.role_id = r.id
+		 JOIN group_members gm ON gm.group_id = gr.group_id
+		 WHERE gm.user_id=$1
+		 ORDER BY 1`, userID)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowTo[string])
+}
+
+// RolesOf returns the request user's roles, loading them once per request
+func RolesOf(c *fiber.Ctx) ([]string, error) {
+	if roles, ok := c.Locals("roles").([]string); ok {
+		return roles, nil
+	}
+	roles, err := Roles(c.Context(), c.Locals("userID").(int))
+	if err != nil {
+		return nil, err
+	}
+	c.Locals("roles", roles)
+	return roles, nil
+}
+
+// IsAdmin reports whether the request was made by an admin user
+func IsAdmin(c *fiber.Ctx) bool {
+	admin, _ := c.Locals("isAdmin").(bool)
+	return admin
+}
+
+// setOrg stores the caller's organization on the request for OrgOf
+func setOrg(c *fiber.Ctx, id int, slug string, admin bool) {
+	c.Locals("orgID", id)
+	c.Locals("orgSlug", slug)
+	c.Locals("orgAdmin", admin)
+}
+
+// OrgOf returns the request user's organization; id 0 means none
+func OrgOf(c *fiber.Ctx) (id int, slug string) {
+	id, _ = c.Locals("orgID").(int)
+	slug, _ = c.Locals("orgSlug").(string)
+	return id, slug
+}
+
+// RequireOrgAdmin admits admins of the caller's own organization. Use
+// after Protect.
+func RequireOrgAdmin() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if admin, _ := c.Locals("orgAdmin").(bool); !admin {
+			return fiber.NewError(fiber.StatusForbidden, "organization admin only")
+		}
+		return c.Next()
+	}
+}
+
+// CanUseGPT reports whether the request may use cfg: an API key must be
+// scoped to it, an org-scoped GPT is limited to that organization, and a
+// restricted GPT needs one of its allowed roles. Admins pass the last two,
+// over an API key only if the key has CapAdmin. Use after Protect.
+func CanUseGPT(c *fiber.Ctx, cfg *gpt.GPTConfig) bool {
+	if !ScopeOf(c).AllowsSlug(cfg.Slug) {
+		return false
+	}
+	if IsAdmin(c) && ScopeOf(c).Can(CapAdmin) {
+		return true
+	}
+	if _, orgSlug := OrgOf(c); cfg.Org != "" && cfg.Org != orgSlug {
+		return false
+	}
+	if cfg.Visibility != gpt.VisibilityRestricted {
+		return true
+	}
+	roles, err := RolesOf(c)
+	if err != nil {
+		log.Printf("roles of user %v: %v", c.Locals("userID"), err)
+		return false
+	}
+	return cfg.Allows(roles)
+}
+
+// syncOIDCGroups makes the user's membership of groups linked to an
+// identity provider group (groups.oidc_group) match the login's groups.
+func syncOIDCGroups(ctx context.Context, tx pgx.Tx, userID int, groups []string) error {
+	if groups == nil {
+		groups = []string{}
+	}
+	_, err := tx.Exec(ctx,
+		`DELETE FROM group_members WHERE user_id=$1 AND group_id IN
+		 (SELECT id FROM groups WHERE oidc_group IS NOT NULL AND NOT oidc_group = ANY($2))`, userID, groups)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx,
+		`INSERT INTO group_members(group_id,user_id)
+		 SELECT id, $1 FROM groups WHERE oidc_group = ANY($2)
+		 ON CONFLICT DO NOTHING`, userID, groups)
+	return err
+}
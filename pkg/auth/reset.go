@@ -0,0 +1,28 @@
+package auth
+
+import (
+	"bytes"
+	_ "embed"
+	"html/template"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+//go:embed reset.html
+var resetPage string
+
+var resetTemplate = template.Must(template.New("reset").Parse(resetPage))
+
+// ResetPasswordPage handles GET /password/reset?token=, the page the reset
+// email links to. Its form posts the new password to ResetPassword.
+func ResetPasswordPage(c *fiber.Ctx) error {
+	var b bytes.Buffer
+	if err := resetTemplate.Execute(&b, fiber.Map{"Token": c.Query("token")}); err != nil {
+		return err
+	}
+	// keep the token out of caches and other sites' Referer headers
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	c.Set("Referrer-Policy", "no-referrer")
+	c.Type("html")
+	return c.Send(b.Bytes())
+}
@@ -0,0 +1,272 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v4"
+	redis "github.com/redis/go-redis/v9"
+
+	"github.com/zeelrupapara/custom-ai-server/pkg/config"
+	"github.com/zeelrupapara/custom-ai-server/pkg/db"
+	"github.com/zeelrupapara/custom-ai-server/pkg/org"
+)
+
+// refreshTokenPrefix marks opaque refresh tokens
+const refreshTokenPrefix = "rt_"
+
+var (
+	errSessionRevoked  = errors.New("session revoked")
+	errAccountDisabled = fiber.NewError(fiber.StatusForbidden, "account disabled")
+)
+
+// tokenPair is returned by Login and Refresh. "token" mirrors access_token
+// for clients written against the original login response.
+type tokenPair struct {
+	Token        string `json:"token"`
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// refreshRecord is stored in Redis under the refresh token's hash. All
+// tokens rotated from one login share a family, so a replayed token can
+// revoke the whole chain.
+type refreshRecord struct {
+	UserID  int    `json:"user_id"`
+	Family  string `json:"family"`
+	Version int    `json:"ver"`
+}
+
+func refreshKey(hash string) string      { return "auth:refresh:" + hash }
+func refreshUsedKey(hash string) string  { return "auth:refresh-used:" + hash }
+func familyRevokedKey(fam string) string { return "auth:refresh-family-revoked:" + fam }
+func revokedAccessKey(jti string) string { return "auth:revoked-jti:" + jti }
+
+// AccessClaims is what a valid access token tells us about the caller
+type AccessClaims struct {
+	UserID  int
+	Admin   bool
+	JTI     string
+	Expires time.Time
+
+	// organization, checked against the user's current membership
+	OrgID    int // 0 when the user belongs to no organization
+	OrgSlug  string
+	OrgAdmin bool
+}
+
+// ValidateAccessToken checks an access token's signature and expiry, that
+// it was not logged out, that the user's sessions were not revoked since it
+// was issued, and that its org claim is still the user's organization.
+func ValidateAccessToken(ctx context.Context, tkn string) (*AccessClaims, error) {
+	token, err := jwt.Parse(tkn, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method %v", t.Header["alg"])
+		}
+		return []byte(config.Load().JWTSecret), nil
+	})
+	if err != nil || !token.Valid {
+		return nil, fiber.ErrUnauthorized
+	}
+	claims := token.Claims.(jwt.MapClaims)
+	sub, ok := claims["sub"].(float64)
+	if !ok {
+		return nil, fiber.ErrUnauthorized
+	}
+	ac := &AccessClaims{UserID: int(sub), Admin: claims["admin"] == true}
+	ac.JTI, _ = claims["jti"].(string)
+	if exp, ok := claims["exp"].(float64); ok {
+		ac.Expires = time.Unix(int64(exp), 0)
+	}
+	ver, _ := claims["ver"].(float64)
+	orgClaim, _ := claims["org"].(float64)
+
+	var (
+		current  int
+		disabled bool
+		orgRole  string
+	)
+	err = db.PG.QueryRow(ctx,
+		`SELECT u.token_version, u.disabled_at IS NOT NULL, COALESCE(u.org_id,0), COALESCE(o.slug,''), u.org_role
+		 FROM users u LEFT JOIN organizations o ON o.id = u.org_id WHERE u.id=$1`, ac.UserID).
+		Scan(&current, &disabled, &ac.OrgID, &ac.OrgSlug, &orgRole)
+	if err != nil {
+		return nil, fiber.ErrUnauthorized
+	}
+	if int(ver) != current || disabled || int(orgClaim) != ac.OrgID {
+		return nil, errSessionRevoked
+	}
+	ac.OrgAdmin = ac.OrgID != 0 && orgRole == org.RoleAdmin
+	if ac.JTI != "" {
+		n, err := db.RDB.Exists(ctx, revokedAccessKey(ac.JTI)).Result()
+		if err != nil {
+			return nil, err
+		}
+		if n > 0 {
+			return nil, errSessionRevoked
+		}
+	}
+	return ac, nil
+}
+
+// issueTokens signs an access token and stores a new refresh token in
+// family (a fresh family when empty).
+func issueTokens(ctx context.Context, userID int, isAdmin bool, version int, family string) (*tokenPair, error) {
+	cfg := config.Load()
+	var orgID int
+	if err := db.PG.QueryRow(ctx, `SELECT COALESCE(org_id,0) FROM users WHERE id=$1`, userID).Scan(&orgID); err != nil {
+		return nil, err
+	}
+	claims := jwt.MapClaims{
+		"sub":   userID,
+		"admin": isAdmin,
+		"org":   orgID,
+		"ver":   version,
+		"jti":   randomHex(16),
+		"exp":   time.Now().Add(cfg.AccessTokenTTL).Unix(),
+	}
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(cfg.JWTSecret))
+	if err != nil {
+		return nil, err
+	}
+
+	if family == "" {
+		family = randomHex(16)
+	}
+	refresh := refreshTokenPrefix + randomHex(32)
+	rec, _ := json.Marshal(refreshRecord{UserID: userID, Family: family, Version: version})
+	if err := db.RDB.Set(ctx, refreshKey(hashToken(refresh)), rec, cfg.RefreshTokenTTL).Err(); err != nil {
+		return nil, err
+	}
+	return &tokenPair{
+		Token:        signed,
+		AccessToken:  signed,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(cfg.AccessTokenTTL.Seconds()),
+		RefreshToken: refresh,
+	}, nil
+}
+
+// Refresh rotates a refresh token: the presented token is consumed and a
+// new access/refresh pair is returned. Presenting an already-used token
+// revokes every token descended from the same login.
+func Refresh(c *fiber.Ctx) error {
+	type req struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	var body req
+	if err := c.BodyParser(&body); err != nil || body.RefreshToken == "" {
+		return fiber.ErrBadRequest
+	}
+	ctx := c.Context()
+	ttl := config.Load().RefreshTokenTTL
+	hash := hashToken(body.RefreshToken)
+
+	raw, err := db.RDB.GetDel(ctx, refreshKey(hash)).Result()
+	if errors.Is(err, redis.Nil) {
+		// unknown, expired or already rotated; a replay burns the family
+		if fam, err := db.RDB.Get(ctx, refreshUsedKey(hash)).Result(); err == nil {
+			db.RDB.Set(ctx, familyRevokedKey(fam), 1, ttl)
+		}
+		return fiber.ErrUnauthorized
+	}
+	if err != nil {
+		return fiber.ErrInternalServerError
+	}
+	var rec refreshRecord
+	if err := json.Unmarshal([]byte(raw), &rec); err != nil {
+		return fiber.ErrUnauthorized
+	}
+	db.RDB.Set(ctx, refreshUsedKey(hash), rec.Family, ttl)
+	if n, _ := db.RDB.Exists(ctx, familyRevokedKey(rec.Family)).Result(); n > 0 {
+		return fiber.ErrUnauthorized
+	}
+
+	var (
+		isAdmin  bool
+		version  int
+		disabled bool
+	)
+	err = db.PG.QueryRow(ctx, `SELECT is_admin, token_version, disabled_at IS NOT NULL FROM users WHERE id=$1`, rec.UserID).
+		Scan(&isAdmin, &version, &disabled)
+	if err != nil || version != rec.Version {
+		return fiber.ErrUnauthorized
+	}
+	if disabled {
+		return errAccountDisabled
+	}
+	pair, err := issueTokens(ctx, rec.UserID, isAdmin, version, rec.Family)
+	if err != nil {
+		return fiber.ErrInternalServerError
+	}
+	return c.JSON(pair)
+}
+
+// Logout revokes the access token used for the request and, if given in
+// the body, the refresh token chain it came with.
+func Logout(c *fiber.Ctx) error {
+	jti, _ := c.Locals("tokenJTI").(string)
+	if ScopeOf(c) != nil || jti == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "logout requires a session token")
+	}
+	ctx := c.Context()
+	if exp, ok := c.Locals("tokenExp").(time.Time); ok && time.Until(exp) > 0 {
+		if err := db.RDB.Set(ctx, revokedAccessKey(jti), 1, time.Until(exp)).Err(); err != nil {
+			return fiber.ErrInternalServerError
+		}
+	}
+
+	type req struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	var body req
+	c.BodyParser(&body)
+	if body.RefreshToken != "" {
+		key := refreshKey(hashToken(body.RefreshToken))
+		raw, err := db.RDB.Get(ctx, key).Result()
+		var rec refreshRecord
+		if err == nil && json.Unmarshal([]byte(raw), &rec) == nil && rec.UserID == c.Locals("userID").(int) {
+			db.RDB.Del(ctx, key)
+			db.RDB.Set(ctx, familyRevokedKey(rec.Family), 1, config.Load().RefreshTokenTTL)
+		}
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// RevokeAllSessions logs the caller out everywhere
+func RevokeAllSessions(c *fiber.Ctx) error {
+	if err := sessionOnly(c); err != nil {
+		return err
+	}
+	if err := RevokeUserSessions(c.Context(), c.Locals("userID").(int)); err != nil {
+		return fiber.ErrInternalServerError
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// RevokeUserSessions invalidates every access and refresh token of userID
+// by bumping their token version. API keys are unaffected.
+func RevokeUserSessions(ctx context.Context, userID int) error {
+	_, err := db.PG.Exec(ctx, `UPDATE users SET token_version = token_version + 1 WHERE id=$1`, userID)
+	return err
+}
+
+func hashToken(tkn string) string {
+	sum := sha256.Sum256([]byte(tkn))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
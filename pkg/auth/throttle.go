@@ -0,0 +1,70 @@
+package auth
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/zeelrupapara/custom-ai-server/pkg/config"
+	"github.com/zeelrupapara/custom-ai-server/pkg/db"
+)
+
+// Failed logins are counted per account and per client IP. Reaching the
+// limit within LOGIN_FAILURE_WINDOW_MIN locks that account or IP out for
+// LOGIN_LOCKOUT_MIN. Unknown usernames are counted like real ones.
+
+func loginFailKey(kind, id string) string { return "auth:login-fail:" + kind + ":" + id }
+func loginLockKey(kind, id string) string { return "auth:login-lock:" + kind + ":" + id }
+
+// loginSubjects returns the throttling subjects of a login attempt
+func loginSubjects(username, ip string) [][2]string {
+	return [][2]string{{"user", strings.ToLower(username)}, {"ip", ip}}
+}
+
+// loginLockedFor returns how long the account or IP is still locked out.
+// Redis errors fail open.
+func loginLockedFor(ctx context.Context, username, ip string) time.Duration {
+	var longest time.Duration
+	for _, s := range loginSubjects(username, ip) {
+		ttl, err := db.RDB.PTTL(ctx, loginLockKey(s[0], s[1])).Result()
+		if err != nil {
+			zap.L().Warn("login lockout check failed", zap.Error(err))
+			return 0
+		}
+		if ttl > longest {
+			longest = ttl
+		}
+	}
+	return longest
+}
+
+// recordLoginFailure counts a failed attempt and locks out whichever of the
+// account and IP reached its limit.
+func recordLoginFailure(ctx context.Context, username, ip string) {
+	cfg := config.Load()
+	limits := map[string]int{"user": cfg.LoginMaxFailures, "ip": cfg.LoginIPMaxFailures}
+	for _, s := range loginSubjects(username, ip) {
+		key := loginFailKey(s[0], s[1])
+		pipe := db.RDB.TxPipeline()
+		incr := pipe.Incr(ctx, key)
+		pipe.ExpireNX(ctx, key, cfg.LoginFailureWindow)
+		if _, err := pipe.Exec(ctx); err != nil {
+			zap.L().Warn("login failure count failed", zap.Error(err))
+			return
+		}
+		if limit := limits[s[0]]; limit > 0 && incr.Val() >= int64(limit) {
+			db.RDB.Set(ctx, loginLockKey(s[0], s[1]), 1, cfg.LoginLockout)
+			db.RDB.Del(ctx, key)
+			zap.L().Warn("login locked out",
+				zap.String("subject", s[0]), zap.String("id", s[1]), zap.Duration("for", cfg.LoginLockout))
+		}
+	}
+}
+
+// clearLoginFailures forgets an account's failures after a good login. The
+// IP's count is kept so one valid account can't reset a password spray.
+func clearLoginFailures(ctx context.Context, username string) {
+	db.RDB.Del(ctx, loginFailKey("user", strings.ToLower(username)))
+}
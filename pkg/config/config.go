@@ -16,6 +16,69 @@ type AppConfig struct {
 	ReadTimeout   time.Duration
 	WriteTimeout  time.Duration
 	IdleTimeout   time.Duration
+
+	// Upstream AI retry policy
+	AIMaxRetries     int
+	AIRetryBaseDelay time.Duration
+	AIRetryMaxDelay  time.Duration
+
+	// WebSocket message handling: reject | queue | interrupt
+	WSConcurrencyPolicy string
+	WSQueueSize         int
+
+	// WebSocket connection lifecycle
+	WSPingInterval    time.Duration
+	WSIdleTimeout     time.Duration
+	WSMaxMessageBytes int
+
+	// How long shutdown waits for requests, sockets and runs to finish
+	ShutdownTimeout time.Duration
+
+	// Session tokens
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+
+	// Password policy
+	PasswordMinLength  int
+	PasswordMinClasses int // of lower, upper, digit, symbol
+
+	// Login throttling: failures within the window lock the account or IP
+	LoginMaxFailures   int
+	LoginIPMaxFailures int
+	LoginFailureWindow time.Duration
+	LoginLockout       time.Duration
+
+	// Email: MAILER is smtp | file | log
+	Mailer       string
+	MailFrom     string
+	MailDir      string
+	SMTPAddr     string
+	SMTPUsername string
+	SMTPPassword string
+
+	// Links in emails point at AppBaseURL
+	AppBaseURL       string
+	EmailVerifyTTL   time.Duration
+	PasswordResetTTL time.Duration
+
+	// GPT configs: GPTWatch reloads GPTConfigDir when its files change
+	GPTConfigDir     string
+	GPTWatch         bool
+	GPTWatchDebounce time.Duration
+	// GPTFilesRoot is what relative paths in a GPT's files are resolved against
+	GPTFilesRoot string
+	// Extra attempts for a reply that doesn't match the GPT's response_format
+	ResponseFormatRetries int
+
+	// OIDC single sign-on; disabled when OIDCIssuer is empty
+	OIDCIssuer       string
+	OIDCClientID     string
+	OIDCClientSecret string
+	OIDCRedirectURL  string
+	OIDCScopes       string // space separated
+	OIDCGroupsClaim  string
+	OIDCAdminGroups  string // comma separated
+	OIDCPostLoginURL string // optional browser redirect after login
 }
 
 // Load reads ENV vars into AppConfig
@@ -32,5 +95,82 @@ func Load() *AppConfig {
 		ReadTimeout:  time.Duration(readTimeout) * time.Second,
 		WriteTimeout: time.Duration(writeTimeout) * time.Second,
 		IdleTimeout:  time.Duration(idleTimeout) * time.Second,
+
+		AIMaxRetries:     envInt("AI_MAX_RETRIES", 3),
+		AIRetryBaseDelay: time.Duration(envInt("AI_RETRY_BASE_MS", 500)) * time.Millisecond,
+		AIRetryMaxDelay:  time.Duration(envInt("AI_RETRY_MAX_MS", 8000)) * time.Millisecond,
+
+		WSConcurrencyPolicy: envString("WS_CONCURRENCY_POLICY", "queue"),
+		WSQueueSize:         envInt("WS_QUEUE_SIZE", 16),
+
+		WSPingInterval:    time.Duration(envInt("WS_PING_INTERVAL_SEC", 30)) * time.Second,
+		WSIdleTimeout:     time.Duration(envInt("WS_IDLE_TIMEOUT_SEC", 600)) * time.Second,
+		WSMaxMessageBytes: envInt("WS_MAX_MESSAGE_BYTES", 64*1024),
+
+		ShutdownTimeout: time.Duration(envInt("SHUTDOWN_TIMEOUT_SEC", 30)) * time.Second,
+
+		AccessTokenTTL:  time.Duration(envInt("ACCESS_TOKEN_TTL_MIN", 15)) * time.Minute,
+		RefreshTokenTTL: time.Duration(envInt("REFRESH_TOKEN_TTL_HOURS", 720)) * time.Hour,
+
+		PasswordMinLength:  envInt("PASSWORD_MIN_LENGTH", 12),
+		PasswordMinClasses: envInt("PASSWORD_MIN_CLASSES", 3),
+
+		LoginMaxFailures:   envInt("LOGIN_MAX_FAILURES", 5),
+		LoginIPMaxFailures: envInt("LOGIN_IP_MAX_FAILURES", 20),
+		LoginFailureWindow: time.Duration(envInt("LOGIN_FAILURE_WINDOW_MIN", 15)) * time.Minute,
+		LoginLockout:       time.Duration(envInt("LOGIN_LOCKOUT_MIN", 15)) * time.Minute,
+
+		Mailer:       envString("MAILER", "log"),
+		MailFrom:     envString("MAIL_FROM", "custom-ai-server <no-reply@localhost>"),
+		MailDir:      envString("MAIL_DIR", "mail"),
+		SMTPAddr:     os.Getenv("SMTP_ADDR"),
+		SMTPUsername: os.Getenv("SMTP_USERNAME"),
+		SMTPPassword: os.Getenv("SMTP_PASSWORD"),
+
+		AppBaseURL:       envString("APP_BASE_URL", "http://localhost:8080"),
+		EmailVerifyTTL:   time.Duration(envInt("EMAIL_VERIFY_TTL_HOURS", 48)) * time.Hour,
+		PasswordResetTTL: time.Duration(envInt("PASSWORD_RESET_TTL_MIN", 30)) * time.Minute,
+
+		GPTConfigDir:     envString("GPT_CONFIG_DIR", "configs/gpts"),
+		GPTWatch:         envBool("GPT_WATCH", false),
+		GPTWatchDebounce: time.Duration(envInt("GPT_WATCH_DEBOUNCE_MS", 500)) * time.Millisecond,
+		GPTFilesRoot:     envString("GPT_FILES_ROOT", "."),
+
+		ResponseFormatRetries: max(envInt("RESPONSE_FORMAT_RETRIES", 2), 0),
+
+		OIDCIssuer:       os.Getenv("OIDC_ISSUER"),
+		OIDCClientID:     os.Getenv("OIDC_CLIENT_ID"),
+		OIDCClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+		OIDCRedirectURL:  os.Getenv("OIDC_REDIRECT_URL"),
+		OIDCScopes:       envString("OIDC_SCOPES", "openid profile email"),
+		OIDCGroupsClaim:  envString("OIDC_GROUPS_CLAIM", "groups"),
+		OIDCAdminGroups:  os.Getenv("OIDC_ADMIN_GROUPS"),
+		OIDCPostLoginURL: os.Getenv("OIDC_POST_LOGIN_URL"),
+	}
+}
+
+// envInt reads an integer ENV var, falling back to def when unset or invalid
+func envInt(key string, def int) int {
+	v, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return def
+	}
+	return v
+}
+
+// envBool reads a boolean ENV var, falling back to def when unset or invalid
+func envBool(key string, def bool) bool {
+	v, err := strconv.ParseBool(os.Getenv(key))
+	if err != nil {
+		return def
+	}
+	return v
+}
+
+// envString reads a string ENV var, falling back to def when unset
+func envString(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
 	}
+	return def
 }
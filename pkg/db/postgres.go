@@ -22,3 +22,10 @@ func ConnectPostgres() error {
 	PG = pool
 	return nil
 }
+
+// ClosePostgres closes the global PG pool, waiting for acquired connections
+func ClosePostgres() {
+	if PG != nil {
+		PG.Close()
+	}
+}
@@ -19,3 +19,11 @@ func ConnectRedis() error {
 	})
 	return RDB.Ping(context.Background()).Err()
 }
+
+// CloseRedis closes the global Redis client
+func CloseRedis() error {
+	if RDB == nil {
+		return nil
+	}
+	return RDB.Close()
+}
@@ -0,0 +1,398 @@
+package dispatcher
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+
+	"github.com/zeelrupapara/custom-ai-server/pkg/ai"
+	"github.com/zeelrupapara/custom-ai-server/pkg/config"
+	"github.com/zeelrupapara/custom-ai-server/pkg/db"
+	"github.com/zeelrupapara/custom-ai-server/pkg/gpt"
+	"github.com/zeelrupapara/custom-ai-server/pkg/i18n"
+	"github.com/zeelrupapara/custom-ai-server/pkg/org"
+	"github.com/zeelrupapara/custom-ai-server/pkg/ratelimit"
+)
+
+// Caller is who a GPT is answering; it fills in the prompt variables.
+type Caller struct {
+	UserID int
+	OrgID  int    // 0 when the user belongs to no organization
+	Locale string // BCP 47 tag; empty means "en"
+
+	identity *identity // set by Remembered
+}
+
+// identity caches the user and organization a Caller's prompts show
+type identity struct {
+	mu     sync.Mutex
+	loaded bool
+	user   gpt.PromptUser
+	org    gpt.PromptOrg
+}
+
+// Remembered returns c with a cache for the user and organization details
+// prompts show, so a long-lived caller such as a WebSocket looks them up
+// once rather than on every message. Renames show up on the next one.
+func (c Caller) Remembered() Caller {
+	c.identity = &identity{}
+	return c
+}
+
+// Request is one user message for a GPT, whatever transport it came from.
+type Request struct {
+	Caller
+	Slug           string
+	ConversationID string // empty starts a new conversation
+	Message        string
+}
+
+// Reply is the assistant's answer and the conversation it belongs to.
+// Data is the answer parsed, for GPTs with a response_format.
+type Reply struct {
+	ConversationID string          `json:"conversation_id"`
+	Content        string          `json:"reply"`
+	Data           json.RawMessage `json:"data,omitempty"`
+}
+
+var (
+	ErrUnknownGPT           = errors.New("unknown GPT")
+	ErrConversationNotFound = errors.New("conversation not found")
+	ErrRateLimited          = errors.New("rate limit exceeded")
+	// ErrRateLimitAbuse means the caller kept sending at twice the limit
+	ErrRateLimitAbuse = fmt.Errorf("%w repeatedly", ErrRateLimited)
+	// ErrInvalidReply means the replies kept failing the GPT's response_format
+	ErrInvalidReply = errors.New("reply doesn't match the GPT's response format")
+)
+
+// Lookup returns the config registered under slug.
+func Lookup(slug string) (*gpt.GPTConfig, error) {
+	cfg, ok := gpt.Get(slug)
+	if !ok {
+		return nil, ErrUnknownGPT
+	}
+	return cfg, nil
+}
+
+// Admit checks the monthly message quota of orgID and then counts one
+// message from userID against cfg.RateLimit, so a message refused for the
+// quota doesn't use up the rate window. The window is shared by every
+// transport, so WebSocket and HTTP draw on one budget. The quota itself is
+// charged by Send and Complete once a reply is ready.
+func Admit(ctx context.Context, userID, orgID int, cfg *gpt.GPTConfig) error {
+	if err := org.CheckMessages(ctx, orgID); err != nil {
+		return err
+	}
+	return admitRate(ctx, userID, cfg)
+}
+
+// admitRate applies cfg.RateLimit to userID
+func admitRate(ctx context.Context, userID int, cfg *gpt.GPTConfig) error {
+	if cfg.RateLimit == "" {
+		return nil
+	}
+	limit, err := ratelimit.Parse(cfg.RateLimit)
+	if err != nil {
+		log.Printf("GPT %s: %v; not rate limiting", cfg.Slug, err)
+		return nil
+	}
+	n, err := ratelimit.Hit(ctx, fmt.Sprintf("chat:%s:%d", cfg.Slug, userID), limit)
+	switch {
+	case err != nil:
+		// fail open: a Redis hiccup should not take chat down
+		log.Printf("rate limit check for %s: %v", cfg.Slug, err)
+		return nil
+	case n > 2*limit.Count:
+		return ErrRateLimitAbuse
+	case n > limit.Count:
+		return ErrRateLimited
+	}
+	return nil
+}
+
+// assistant is a cached upstream assistant for one GPT config
+type assistant struct {
+	cfg   *gpt.GPTConfig
+	ready chan struct{}
+	model *ai.AI
+	err   error
+}
+
+// retireAfter is how long a replaced assistant is kept so runs already
+// using it can finish before it is deleted upstream.
+const retireAfter = 10 * time.Minute
+
+var (
+	mu         sync.Mutex
+	assistants = map[string]*assistant{}
+)
+
+// Prepare returns the assistant backing cfg, creating it on first use.
+// Every conversation with a GPT shares one assistant; a config changed by a
+// reload (a new *GPTConfig) gets a fresh one and the old one is retired.
+func Prepare(ctx context.Context, cfg *gpt.GPTConfig) (*ai.AI, error) {
+	mu.Lock()
+	a, ok := assistants[cfg.Slug]
+	if !ok || a.cfg != cfg {
+		if ok {
+			go a.retire()
+		}
+		a = &assistant{cfg: cfg, ready: make(chan struct{})}
+		assistants[cfg.Slug] = a
+		go a.create()
+	}
+	mu.Unlock()
+
+	select {
+	case <-a.ready:
+		return a.model, a.err
+	case <-ctx.Done():
+		return nil, &ai.Error{Kind: ai.KindCancelled, Op: "prepare assistant", Err: ctx.Err()}
+	}
+}
+
+// create builds the assistant detached from any one caller's context; a
+// failure is forgotten so the next Prepare tries again.
+func (a *assistant) create() {
+	defer close(a.ready)
+	a.model, a.err = ai.NewAI(context.Background(), a.cfg.Model, a.cfg.SystemPrompt, a.cfg.Name, a.cfg.FilePaths(), a.cfg.Temperature)
+	if a.err != nil {
+		mu.Lock()
+		if assistants[a.cfg.Slug] == a {
+			delete(assistants, a.cfg.Slug)
+		}
+		mu.Unlock()
+	}
+}
+
+// retire deletes a replaced assistant upstream once it is built and the
+// runs started on it have had retireAfter to finish.
+func (a *assistant) retire() {
+	<-a.ready
+	if a.model == nil {
+		return
+	}
+	time.Sleep(retireAfter)
+	a.model.Close()
+}
+
+// Send delivers req to its GPT and persists both sides of the exchange.
+// On failure the returned Reply still carries the conversation ID when one
+// was resolved, so callers can keep using it.
+func Send(ctx context.Context, req Request) (*Reply, error) {
+	cfg, err := Lookup(req.Slug)
+	if err != nil {
+		return nil, err
+	}
+	model, err := Prepare(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	convID, threadID, err := conversation(ctx, req, model)
+	if err != nil {
+		return nil, err
+	}
+	reply := &Reply{ConversationID: convID}
+
+	prompt, err := systemPrompt(ctx, cfg, req.Caller)
+	if err != nil {
+		return reply, err
+	}
+	opts := ai.RunOptions{Instructions: prompt, Schema: schemaOf(cfg)}
+	record(convID, req.UserID, cfg, "user", req.Message)
+	reply.Content, err = model.Chat(ctx, threadID, req.Message, opts)
+	if err != nil {
+		return reply, err
+	}
+	// corrections are removed from the thread again, with the replies they
+	// correct, so it holds only what the user saw
+	reply.Content, reply.Data, err = conform(cfg, reply.Content, func(_, correction string) (string, error) {
+		return model.Retry(ctx, threadID, correction, opts)
+	})
+	if errors.Is(err, ErrInvalidReply) {
+		if derr := model.DiscardReply(context.WithoutCancel(ctx), threadID); derr != nil {
+			log.Printf("GPT %s: discarding invalid reply: %v", cfg.Slug, derr)
+		}
+	}
+	if err != nil {
+		return reply, err
+	}
+	record(convID, req.UserID, cfg, "assistant", reply.Content)
+	org.CountMessage(context.WithoutCancel(ctx), req.OrgID)
+	return reply, nil
+}
+
+// Complete answers a conversation the client keeps itself (the OpenAI
+// chat-completions shape). Nothing is persisted server-side.
+func Complete(ctx context.Context, cfg *gpt.GPTConfig, caller Caller, messages []ai.Message, instructions string) (string, ai.Usage, error) {
+	model, err := Prepare(ctx, cfg)
+	if err != nil {
+		return "", ai.Usage{}, err
+	}
+	prompt, err := systemPrompt(ctx, cfg, caller)
+	if err != nil {
+		return "", ai.Usage{}, err
+	}
+	opts := ai.RunOptions{Instructions: prompt, AdditionalInstructions: instructions, Schema: schemaOf(cfg)}
+	reply, usage, err := model.Complete(ctx, messages, opts)
+	if err != nil {
+		return "", usage, err
+	}
+	reply, _, err = conform(cfg, reply, func(bad, correction string) (string, error) {
+		messages = append(messages, ai.Message{Role: "assistant", Content: bad}, ai.Message{Role: "user", Content: correction})
+		again, u, err := model.Complete(ctx, messages, opts)
+		usage.PromptTokens += u.PromptTokens
+		usage.CompletionTokens += u.CompletionTokens
+		usage.TotalTokens += u.TotalTokens
+		return again, err
+	})
+	if err != nil {
+		return "", usage, err
+	}
+	org.CountMessage(context.WithoutCancel(ctx), caller.OrgID)
+	return reply, usage, nil
+}
+
+// schemaOf is the structured output cfg asks for, nil for plain text
+func schemaOf(cfg *gpt.GPTConfig) *ai.JSONSchema {
+	f := cfg.ResponseFormat
+	if f == nil {
+		return nil
+	}
+	return &ai.JSONSchema{Name: f.Name, Description: f.Description, Strict: f.IsStrict(), Schema: f.Schema}
+}
+
+// conform checks reply against cfg's response_format. While it doesn't
+// match, retry is called with the bad reply and a message explaining why,
+// up to RESPONSE_FORMAT_RETRIES times, and its reply is checked instead.
+func conform(cfg *gpt.GPTConfig, reply string, retry func(bad, correction string) (string, error)) (string, json.RawMessage, error) {
+	if cfg.ResponseFormat == nil {
+		return reply, nil, nil
+	}
+	for attempt := 0; ; attempt++ {
+		data, err := cfg.ResponseFormat.Parse(reply)
+		if err == nil {
+			return reply, data, nil
+		}
+		if attempt >= config.Load().ResponseFormatRetries {
+			log.Printf("GPT %s: reply doesn't match response_format after %d attempts: %v", cfg.Slug, attempt+1, err)
+			return "", nil, fmt.Errorf("%w: %v", ErrInvalidReply, err)
+		}
+		correction := fmt.Sprintf("That reply doesn't match the required response format (%v). Reply again with only the JSON object.", err)
+		if reply, err = retry(reply, correction); err != nil {
+			return "", nil, err
+		}
+	}
+}
+
+// maxPromptDocuments caps how many of the caller's uploads a prompt lists
+const maxPromptDocuments = 20
+
+// systemPrompt renders cfg's system prompt for caller. It returns "" when
+// the prompt is plain text, so the assistant's own instructions apply.
+func systemPrompt(ctx context.Context, cfg *gpt.GPTConfig, caller Caller) (string, error) {
+	if !cfg.Templated() {
+		return "", nil
+	}
+	vars := gpt.PromptVars{Locale: caller.Locale}
+	if vars.Locale == "" {
+		vars.Locale = i18n.Default
+	}
+	vars.Language = i18n.Language(vars.Locale)
+	var orgID *int
+	if caller.OrgID != 0 {
+		orgID = &caller.OrgID
+	}
+	var err error
+	if vars.User, vars.Org, err = lookupIdentity(ctx, caller, orgID); err != nil {
+		return "", err
+	}
+	rows, err := db.PG.Query(ctx,
+		`SELECT filename FROM uploads WHERE user_id=$1 AND org_id IS NOT DISTINCT FROM $2
+		 ORDER BY created_at DESC, id DESC LIMIT $3`, caller.UserID, orgID, maxPromptDocuments)
+	if err != nil {
+		return "", err
+	}
+	if vars.Documents, err = pgx.CollectRows(rows, pgx.RowTo[string]); err != nil {
+		return "", err
+	}
+	return cfg.Prompt(vars)
+}
+
+// lookupIdentity returns the user and organization caller's prompts show,
+// from its cache when it has one
+func lookupIdentity(ctx context.Context, caller Caller, orgID *int) (user gpt.PromptUser, o gpt.PromptOrg, err error) {
+	if id := caller.identity; id != nil {
+		id.mu.Lock()
+		defer id.mu.Unlock()
+		if id.loaded {
+			return id.user, id.org, nil
+		}
+	}
+	err = db.PG.QueryRow(ctx,
+		`SELECT u.username, COALESCE(NULLIF(u.display_name,''),u.username), COALESCE(o.name,''), COALESCE(o.slug,'')
+		 FROM users u LEFT JOIN organizations o ON o.id=$2 WHERE u.id=$1`, caller.UserID, orgID).
+		Scan(&user.Username, &user.Name, &o.Name, &o.Slug)
+	if err != nil {
+		return user, o, err
+	}
+	if id := caller.identity; id != nil {
+		id.user, id.org, id.loaded = user, o, true
+	}
+	return user, o, nil
+}
+
+// conversation resolves req.ConversationID to its upstream thread, or
+// starts a new conversation when the request has none. Conversations made
+// in another organization are not found.
+func conversation(ctx context.Context, req Request, model *ai.AI) (id, threadID string, err error) {
+	var orgID *int
+	if req.OrgID != 0 {
+		orgID = &req.OrgID
+	}
+	if req.ConversationID != "" {
+		err = db.PG.QueryRow(ctx,
+			`SELECT thread_id FROM conversations
+			 WHERE id=$1 AND user_id=$2 AND slug=$3 AND org_id IS NOT DISTINCT FROM $4`,
+			req.ConversationID, req.UserID, req.Slug, orgID).Scan(&threadID)
+		var pgErr *pgconn.PgError
+		switch {
+		case errors.Is(err, pgx.ErrNoRows),
+			errors.As(err, &pgErr) && pgErr.Code == "22P02": // malformed UUID
+			return "", "", ErrConversationNotFound
+		case err != nil:
+			return "", "", err
+		}
+		return req.ConversationID, threadID, nil
+	}
+
+	threadID, err = model.NewThread(ctx)
+	if err != nil {
+		return "", "", err
+	}
+	err = db.PG.QueryRow(ctx,
+		`INSERT INTO conversations(user_id,org_id,slug,thread_id) VALUES($1,$2,$3,$4) RETURNING id`,
+		req.UserID, orgID, req.Slug, threadID).Scan(&id)
+	if err != nil {
+		return "", "", err
+	}
+	return id, threadID, nil
+}
+
+// record appends a message to chat_history. It runs detached from the
+// request context so cancelled generations still keep their transcript.
+func record(convID string, userID int, cfg *gpt.GPTConfig, role, message string) {
+	_, err := db.PG.Exec(context.Background(),
+		`INSERT INTO chat_history(user_id,slug,conversation_id,role,message,config_version)
+		 VALUES($1,$2,$3,$4,$5,$6)`, userID, cfg.Slug, convID, role, message, cfg.Version)
+	if err != nil {
+		log.Printf("chat history for conversation %s: %v", convID, err)
+	}
+}
@@ -0,0 +1,92 @@
+package gpt
+
+import (
+	"encoding/json"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// Change is one field that differs between two definitions. Multi-line
+// text also gets a line diff, each line prefixed with "  ", "- " or "+ ".
+type Change struct {
+	Field string   `json:"field"`
+	From  any      `json:"from"`
+	To    any      `json:"to"`
+	Lines []string `json:"lines,omitempty"`
+}
+
+// DiffDefinitions compares two stored definitions field by field
+func DiffDefinitions(from, to json.RawMessage) ([]Change, error) {
+	var a, b map[string]any
+	if err := json.Unmarshal(from, &a); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(to, &b); err != nil {
+		return nil, err
+	}
+	fields := slices.Sorted(maps.Keys(a))
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			fields = append(fields, k)
+		}
+	}
+	slices.Sort(fields)
+
+	changes := []Change{}
+	for _, f := range fields {
+		if reflect.DeepEqual(a[f], b[f]) {
+			continue
+		}
+		ch := Change{Field: f, From: a[f], To: b[f]}
+		x, _ := a[f].(string)
+		y, _ := b[f].(string)
+		if strings.Contains(x, "\n") || strings.Contains(y, "\n") {
+			ch.Lines = lineDiff(strings.Split(x, "\n"), strings.Split(y, "\n"))
+		}
+		changes = append(changes, ch)
+	}
+	return changes, nil
+}
+
+// lineDiff lists a and b merged along their longest common subsequence
+func lineDiff(a, b []string) []string {
+	// lcs[i][j] is the LCS length of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	var out []string
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			out = append(out, "  "+a[i])
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			out = append(out, "- "+a[i])
+			i++
+		default:
+			out = append(out, "+ "+b[j])
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		out = append(out, "- "+a[i])
+	}
+	for ; j < len(b); j++ {
+		out = append(out, "+ "+b[j])
+	}
+	return out
+}
@@ -0,0 +1,56 @@
+package gpt
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLineDiff(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		want []string
+	}{
+		{"equal", "x\ny", "x\ny", []string{"  x", "  y"}},
+		{"append", "x", "x\ny", []string{"  x", "+ y"}},
+		{"remove", "x\ny\nz", "x\nz", []string{"  x", "- y", "  z"}},
+		{"replace", "x\ny\nz", "x\nY\nz", []string{"  x", "- y", "+ Y", "  z"}},
+		{"from empty", "", "x", []string{"- ", "+ x"}},
+		{"reorder", "a\nb\nc", "c\na\nb", []string{"+ c", "  a", "  b", "- c"}},
+	}
+	for _, tt := range tests {
+		got := lineDiff(strings.Split(tt.a, "\n"), strings.Split(tt.b, "\n"))
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: lineDiff = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDiffDefinitions(t *testing.T) {
+	from := `{"slug":"x","name":"X","system_prompt":"a\nb","temperature":0.2,"starters":["hi"]}`
+	to := `{"slug":"x","name":"Y","system_prompt":"a\nc","starters":["hi"],"icon":"/x.png"}`
+	changes, err := DiffDefinitions([]byte(from), []byte(to))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fields []string
+	for _, ch := range changes {
+		fields = append(fields, ch.Field)
+	}
+	if want := []string{"icon", "name", "system_prompt", "temperature"}; !reflect.DeepEqual(fields, want) {
+		t.Fatalf("changed fields = %q, want %q", fields, want)
+	}
+	if got, want := changes[2].Lines, []string{"  a", "- b", "+ c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("system_prompt lines = %q, want %q", got, want)
+	}
+	if changes[1].Lines != nil {
+		t.Errorf("single-line field got a line diff: %q", changes[1].Lines)
+	}
+	if changes[3].From != 0.2 || changes[3].To != nil {
+		t.Errorf("temperature = %v -> %v, want 0.2 -> nil", changes[3].From, changes[3].To)
+	}
+	if _, err := DiffDefinitions([]byte(from), []byte("{")); err == nil {
+		t.Error("invalid JSON accepted")
+	}
+}
@@ -0,0 +1,65 @@
+package gpt
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/zeelrupapara/custom-ai-server/pkg/jsonschema"
+)
+
+// ResponseFormat makes a GPT answer with JSON matching Schema, which is
+// passed to the provider as structured output and checked before delivery
+type ResponseFormat struct {
+	Name        string         `yaml:"name" json:"name"`
+	Description string         `yaml:"description" json:"description,omitempty"`
+	Strict      *bool          `yaml:"strict" json:"strict,omitempty"` // default true
+	Schema      map[string]any `yaml:"schema" json:"schema"`
+
+	compiled *jsonschema.Schema
+}
+
+var formatNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// IsStrict reports whether the provider is asked to follow the schema exactly
+func (f *ResponseFormat) IsStrict() bool {
+	return f.Strict == nil || *f.Strict
+}
+
+// compile checks the format and prepares its schema
+func (f *ResponseFormat) compile() (err error) {
+	switch {
+	case !formatNamePattern.MatchString(f.Name):
+		return fmt.Errorf("name %q must be 1-64 letters, digits, _ or -", f.Name)
+	case f.Schema == nil:
+		return fmt.Errorf("schema is required")
+	case f.Schema["type"] != "object":
+		return fmt.Errorf(`schema must have type "object"`)
+	}
+	f.compiled, err = jsonschema.Compile(f.Schema, f.IsStrict())
+	return err
+}
+
+// Parse checks a reply against the schema and returns it as compact JSON.
+// A reply wrapped in a markdown code fence is accepted.
+func (f *ResponseFormat) Parse(reply string) (json.RawMessage, error) {
+	text := strings.TrimSpace(reply)
+	if strings.HasPrefix(text, "```") && strings.HasSuffix(text, "```") {
+		text = strings.TrimSuffix(text, "```")
+		_, text, _ = strings.Cut(text, "\n")
+	}
+	var v any
+	if err := json.Unmarshal([]byte(text), &v); err != nil {
+		return nil, fmt.Errorf("not valid JSON: %v", err)
+	}
+	if err := f.compiled.Validate(v); err != nil {
+		return nil, err
+	}
+	var b bytes.Buffer
+	if err := json.Compact(&b, []byte(text)); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
@@ -0,0 +1,203 @@
+package gpt
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// basesDir holds the configs GPTs can extend, relative to the config dir
+	basesDir = "bases"
+	// fragmentsDir is where prompt fragments conventionally live
+	fragmentsDir = "fragments"
+)
+
+// resolver reads what definitions share in a config dir: the bases they
+// extend, the fragments they include and the prompt partials. Each is read
+// once per load, and a problem in a base is reported once however many
+// definitions extend it.
+type resolver struct {
+	dir       string
+	partials  map[string]string
+	bases     map[string]*yaml.Node // resolved; nil when broken
+	fragments map[string]string
+	stack     []string              // bases being resolved, for cycle errors
+	origin    map[*yaml.Node]string // file each base node was read from
+	errs      ValidationError       // problems in bases
+}
+
+func newResolver(dir string) *resolver {
+	return &resolver{
+		dir:       dir,
+		bases:     map[string]*yaml.Node{},
+		fragments: map[string]string{},
+		origin:    map[*yaml.Node]string{},
+	}
+}
+
+// resolve returns root merged over the chain of bases it extends. It
+// returns nil without errors when a base is broken; that is in r.errs.
+func (r *resolver) resolve(path string, root *yaml.Node) (*yaml.Node, ValidationError) {
+	ext := fieldNode(root, "extends")
+	if ext == nil {
+		return root, nil
+	}
+	if i := slices.Index(r.stack, ext.Value); i >= 0 {
+		cycle := strings.Join(append(slices.Clone(r.stack[i:]), ext.Value), " -> ")
+		return nil, ValidationError{{File: path, Line: ext.Line, Field: "extends", Msg: "cycle " + cycle}}
+	}
+	if b, ok := r.bases[ext.Value]; ok {
+		if b == nil {
+			return nil, nil
+		}
+		return merge(b, root), nil
+	}
+	if !slugPattern.MatchString(ext.Value) {
+		return nil, ValidationError{{File: path, Line: ext.Line, Field: "extends", Msg: fmt.Sprintf("invalid base name %q", ext.Value)}}
+	}
+	basePath := filepath.Join(r.dir, basesDir, ext.Value+".yaml")
+	data, err := os.ReadFile(basePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ValidationError{{File: path, Line: ext.Line, Field: "extends",
+			Msg: fmt.Sprintf("unknown base %q (looked in %s/)", ext.Value, basesDir)}}
+	}
+	if err != nil {
+		return nil, ValidationError{{File: path, Line: ext.Line, Field: "extends", Msg: err.Error()}}
+	}
+
+	b, errs := readMapping(basePath, data)
+	if errs == nil {
+		if n := fieldNode(b, "slug"); n != nil {
+			errs = ValidationError{{File: basePath, Line: n.Line, Field: "slug", Msg: "a base can't set slug"}}
+		}
+	}
+	if errs == nil {
+		r.track(basePath, b)
+		r.stack = append(r.stack, ext.Value)
+		b, errs = r.resolve(basePath, b)
+		r.stack = r.stack[:len(r.stack)-1]
+	}
+	r.errs = append(r.errs, errs...)
+	r.bases[ext.Value] = b
+	if b == nil {
+		return nil, nil
+	}
+	return merge(b, root), nil
+}
+
+// track remembers that n and everything under it came from path
+func (r *resolver) track(path string, n *yaml.Node) {
+	r.origin[n] = path
+	for _, c := range n.Content {
+		r.track(path, c)
+	}
+}
+
+// file is the file n was read from: the base it was inherited from, or path
+func (r *resolver) file(path string, n *yaml.Node) string {
+	if f, ok := r.origin[n]; ok {
+		return f
+	}
+	return path
+}
+
+// fragment returns the text of an included file, relative to the config
+// dir, without its trailing newlines
+func (r *resolver) fragment(name string) (string, error) {
+	if text, ok := r.fragments[name]; ok {
+		return text, nil
+	}
+	if !filepath.IsLocal(name) {
+		return "", fmt.Errorf("%q must be a relative path inside the config directory", name)
+	}
+	data, err := os.ReadFile(filepath.Join(r.dir, name))
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", fmt.Errorf("%q does not exist", name)
+	}
+	if err != nil {
+		return "", err
+	}
+	text := strings.TrimRight(string(data), "\n")
+	r.fragments[name] = text
+	return text, nil
+}
+
+// merge returns child's fields over base's. A field the child sets replaces
+// the base's whole value, lists included, except includes, which are the
+// base's followed by the child's. extends is the child's own.
+func merge(base, child *yaml.Node) *yaml.Node {
+	out := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", Line: child.Line, Column: child.Column}
+	for i := 0; i+1 < len(base.Content); i += 2 {
+		key, val := base.Content[i], base.Content[i+1]
+		switch own := fieldNode(child, key.Value); {
+		case key.Value == "extends":
+			continue
+		case key.Value == "includes" && own != nil:
+			val = concat(val, own)
+		case own != nil:
+			continue
+		}
+		out.Content = append(out.Content, key, val)
+	}
+	for i := 0; i+1 < len(child.Content); i += 2 {
+		if key := child.Content[i]; key.Value == "includes" && fieldNode(base, "includes") != nil {
+			continue
+		}
+		out.Content = append(out.Content, child.Content[i], child.Content[i+1])
+	}
+	return out
+}
+
+// concat joins two sequences, dropping repeated values
+func concat(a, b *yaml.Node) *yaml.Node {
+	if a.Kind != yaml.SequenceNode || b.Kind != yaml.SequenceNode {
+		return b
+	}
+	out := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq", Line: b.Line, Column: b.Column}
+	seen := map[string]bool{}
+	for _, n := range slices.Concat(a.Content, b.Content) {
+		if !seen[n.Value] {
+			seen[n.Value] = true
+			out.Content = append(out.Content, n)
+		}
+	}
+	return out
+}
+
+// definitionFields are the keys a definition may set, GPTConfig's YAML names
+var definitionFields = func() map[string]bool {
+	fields := map[string]bool{}
+	t := reflect.TypeOf(GPTConfig{})
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ",")
+		if name != "" && name != "-" {
+			fields[name] = true
+		}
+	}
+	return fields
+}()
+
+// definition is a definition as written, as JSON, keeping only the fields
+// GPTConfig knows. Unlike the resolved config it leaves inherited and
+// defaulted fields unset, so they keep following the base.
+func definition(root *yaml.Node) (json.RawMessage, error) {
+	var m map[string]any
+	if err := root.Decode(&m); err != nil {
+		return nil, err
+	}
+	for k := range m {
+		if !definitionFields[k] {
+			delete(m, k)
+		}
+	}
+	return json.Marshal(m)
+}
@@ -0,0 +1,112 @@
+package gpt
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExtends(t *testing.T) {
+	dir := writeDir(t, map[string]string{
+		"bases/root.yaml":  "model: gpt-4o\ntemperature: 0.5\nrate_limit: 10/m\nallowed_roles: [a, b]\nincludes: [fragments/one.md]\n",
+		"bases/mid.yaml":   "extends: root\ntemperature: 0.3\nincludes: [fragments/two.md, fragments/one.md]\n",
+		"fragments/one.md": "One.\n",
+		"fragments/two.md": "Two.\n\n",
+		"x.yaml": `slug: xx
+name: X
+extends: mid
+rate_limit: 30/m
+allowed_roles: [c]
+includes: [fragments/three.md]
+system_prompt: Hello.
+`,
+		"fragments/three.md": "Three.",
+	})
+	cfg := mustLoad(t, dir, "x.yaml")
+	if cfg.Model != "gpt-4o" || cfg.Temperature == nil || *cfg.Temperature != 0.3 || cfg.RateLimit != "30/m" {
+		t.Errorf("model, temperature, rate_limit = %q, %v, %q; want gpt-4o, 0.3 (mid), 30/m (own)",
+			cfg.Model, cfg.Temperature, cfg.RateLimit)
+	}
+	if !reflect.DeepEqual(cfg.AllowedRoles, []string{"c"}) {
+		t.Errorf("allowed_roles = %q, want the GPT's list replacing the base's", cfg.AllowedRoles)
+	}
+	want := []string{"fragments/one.md", "fragments/two.md", "fragments/three.md"}
+	if !reflect.DeepEqual(cfg.Includes, want) {
+		t.Errorf("includes = %q, want %q", cfg.Includes, want)
+	}
+	got, err := cfg.Prompt(PromptVars{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "Hello.\n\nOne.\n\nTwo.\n\nThree."; got != want {
+		t.Errorf("Prompt = %q, want %q", got, want)
+	}
+
+	var def map[string]any
+	if err := json.Unmarshal(cfg.Definition(), &def); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := def["model"]; ok || def["extends"] != "mid" {
+		t.Errorf("definition = %v, want the GPT as written, without inherited fields", def)
+	}
+}
+
+func TestExtendsErrors(t *testing.T) {
+	const head = "slug: xx\nname: X\nsystem_prompt: Hi.\n"
+	tests := []struct {
+		name  string
+		files map[string]string
+		want  string
+	}{
+		{"cycle", map[string]string{
+			"x.yaml":        head + "extends: ab\n",
+			"bases/ab.yaml": "extends: bc\n",
+			"bases/bc.yaml": "extends: ab\nmodel: gpt-4o\n",
+		}, "extends: cycle ab -> bc -> ab"},
+		{"self", map[string]string{
+			"x.yaml":        head + "extends: ab\n",
+			"bases/ab.yaml": "extends: ab\n",
+		}, "extends: cycle ab -> ab"},
+		{"unknown base", map[string]string{
+			"x.yaml": head + "extends: nope\n",
+		}, `unknown base "nope"`},
+		{"invalid base name", map[string]string{
+			"x.yaml": head + "extends: ../x\n",
+		}, `invalid base name "../x"`},
+		{"base sets slug", map[string]string{
+			"x.yaml":        head + "extends: ab\n",
+			"bases/ab.yaml": "slug: other\nmodel: gpt-4o\n",
+		}, "a base can't set slug"},
+		{"bad inherited field", map[string]string{
+			"x.yaml":        head + "extends: ab\n",
+			"bases/ab.yaml": "model: gpt-4o\ntemperature: 3\n",
+		}, "ab.yaml:2: temperature:"},
+		{"fragment outside the dir", map[string]string{
+			"x.yaml": head + "model: gpt-4o\nincludes: [../secret.md]\n",
+		}, "must be a relative path inside the config directory"},
+		{"missing fragment", map[string]string{
+			"x.yaml": head + "model: gpt-4o\nincludes: [fragments/nope.md]\n",
+		}, `"fragments/nope.md" does not exist`},
+	}
+	for _, tt := range tests {
+		_, errs := loadFile(t, writeDir(t, tt.files), "x.yaml")
+		if errs == nil || !strings.Contains(errs.Error(), tt.want) {
+			t.Errorf("%s: errors = %v, want one containing %q", tt.name, errs, tt.want)
+		}
+	}
+}
+
+func TestConcat(t *testing.T) {
+	dir := writeDir(t, map[string]string{
+		"bases/ab.yaml": "model: gpt-4o\nincludes: [f/1.md, f/2.md]\n",
+		"x.yaml":        "slug: xx\nname: X\nsystem_prompt: Hi.\nextends: ab\nincludes: [f/2.md, f/3.md, f/1.md]\n",
+		"f/1.md":        "1",
+		"f/2.md":        "2",
+		"f/3.md":        "3",
+	})
+	cfg := mustLoad(t, dir, "x.yaml")
+	if want := []string{"f/1.md", "f/2.md", "f/3.md"}; !reflect.DeepEqual(cfg.Includes, want) {
+		t.Errorf("includes = %q, want each fragment once, the base's first: %q", cfg.Includes, want)
+	}
+}
@@ -1,47 +1,361 @@
 package gpt
 
 import (
-	"io/ioutil"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"maps"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/template"
 
-	"gopkg.in/yaml.v3"
+	"github.com/jackc/pgx/v5"
+
+	"github.com/zeelrupapara/custom-ai-server/pkg/config"
+	"github.com/zeelrupapara/custom-ai-server/pkg/db"
 )
 
-// GPTConfig represents one agent
+// GPTConfig represents one agent. The JSON form uses the YAML field names,
+// so a definition stored through the admin API reads like a config file.
 type GPTConfig struct {
-	Slug         string   `yaml:"slug"`
-	Name         string   `yaml:"name"`
-	Model        string   `yaml:"model"`
-	SystemPrompt string   `yaml:"system_prompt"`
-	Files        []string `yaml:"files"`
-	RateLimit    string   `yaml:"rate_limit"`
-	Temperature  float32  `yaml:"temperature"`
+	Slug         string   `yaml:"slug" json:"slug"`
+	Name         string   `yaml:"name" json:"name"`
+	Description  string   `yaml:"description" json:"description,omitempty"`
+	Model        string   `yaml:"model" json:"model"`
+	Provider     string   `yaml:"provider" json:"provider"` // default "openai"
+	SystemPrompt string   `yaml:"system_prompt" json:"system_prompt"`
+	Files        []string `yaml:"files" json:"files"`
+	RateLimit    string   `yaml:"rate_limit" json:"rate_limit"`
+	Temperature  *float64 `yaml:"temperature" json:"temperature,omitempty"` // unset uses the model default
+
+	// Org limits the GPT to one organization (by slug); empty is global
+	Org string `yaml:"org" json:"org"`
+
+	// Visibility is "public" (default) or "restricted" to AllowedRoles
+	Visibility   string   `yaml:"visibility" json:"visibility"`
+	AllowedRoles []string `yaml:"allowed_roles" json:"allowed_roles"`
+
+	// Icon is the GPT's avatar: an http(s) URL or a path on this server
+	Icon string `yaml:"icon" json:"icon,omitempty"`
+	// Starters are example first messages clients can offer the user
+	Starters []string `yaml:"starters" json:"starters,omitempty"`
+	// Welcome greets the user when a WebSocket opens; empty uses the
+	// server's message
+	Welcome string `yaml:"welcome" json:"welcome,omitempty"`
+	// Locales translate the strings above, keyed by language tag
+	Locales map[string]*Localization `yaml:"locales" json:"locales,omitempty"`
+
+	// ResponseFormat, if set, makes replies JSON matching its schema
+	ResponseFormat *ResponseFormat `yaml:"response_format" json:"response_format,omitempty"`
+
+	// Extends names a config in bases/ whose fields this one inherits
+	Extends string `yaml:"extends" json:"extends,omitempty"`
+	// Includes are prompt fragments, relative to the config dir, appended
+	// to SystemPrompt in order
+	Includes []string `yaml:"includes" json:"includes,omitempty"`
+
+	// Source is where the GPT was defined: SourceFile or SourceDB
+	Source string `yaml:"-" json:"-"`
+	// Version numbers the GPT's distinct configs; see recordVersions
+	Version int `yaml:"-" json:"-"`
+
+	prompt     *template.Template // compiled SystemPrompt
+	partials   map[string]string  // partials and fragments the prompt uses, by name
+	hash       string             // content hash, partials included
+	definition json.RawMessage    // as written, before inheritance
+}
+
+// FilePaths returns Files with relative paths resolved against
+// GPT_FILES_ROOT rather than the working directory
+func (g *GPTConfig) FilePaths() []string {
+	root := config.Load().GPTFilesRoot
+	paths := make([]string, len(g.Files))
+	for i, f := range g.Files {
+		if filepath.IsAbs(f) {
+			paths[i] = f
+		} else {
+			paths[i] = filepath.Join(root, f)
+		}
+	}
+	return paths
+}
+
+// Definition is the config as written, without what it inherits; storing
+// it keeps the GPT following its base.
+func (g *GPTConfig) Definition() json.RawMessage {
+	return g.definition
+}
+
+// Sources of a GPT definition. A stored definition takes precedence over a
+// file with the same slug.
+const (
+	SourceFile = "file"
+	SourceDB   = "db"
+)
+
+// Visibility values
+const (
+	VisibilityPublic     = "public"
+	VisibilityRestricted = "restricted"
+)
+
+// Allows reports whether a user holding roles may use the GPT. Admins are
+// let through by the caller.
+func (g *GPTConfig) Allows(roles []string) bool {
+	if g.Visibility != VisibilityRestricted {
+		return true
+	}
+	for _, r := range roles {
+		if slices.Contains(g.AllowedRoles, r) {
+			return true
+		}
+	}
+	return false
+}
+
+// snapshot is one loaded set of configs. It is never modified once
+// published, so readers need no lock; the configs in it are shared and must
+// be treated as read-only.
+type snapshot struct {
+	configs map[string]*GPTConfig
+	version string
+}
+
+var (
+	current  atomic.Pointer[snapshot]
+	reloadMu sync.Mutex // serializes LoadConfigs so diffs are against the snapshot replaced
+)
+
+// Get returns the config registered under slug
+func Get(slug string) (*GPTConfig, bool) {
+	s := current.Load()
+	if s == nil {
+		return nil, false
+	}
+	cfg, ok := s.configs[slug]
+	return cfg, ok
+}
+
+// All returns every loaded config, ordered by slug
+func All() []*GPTConfig {
+	s := current.Load()
+	if s == nil {
+		return nil
+	}
+	out := make([]*GPTConfig, 0, len(s.configs))
+	for _, cfg := range s.configs {
+		out = append(out, cfg)
+	}
+	slices.SortFunc(out, func(a, b *GPTConfig) int { return strings.Compare(a.Slug, b.Slug) })
+	return out
+}
+
+// Version identifies the loaded configs by content: replicas that loaded
+// the same configs report the same version.
+func Version() string {
+	if s := current.Load(); s != nil {
+		return s.version
+	}
+	return ""
+}
+
+// version hashes the configs' content hashes in slug order
+func version(configs map[string]*GPTConfig) string {
+	h := sha256.New()
+	for _, slug := range slices.Sorted(maps.Keys(configs)) {
+		fmt.Fprintf(h, "%s %s\n", slug, configs[slug].hash)
+	}
+	return hex.EncodeToString(h.Sum(nil))[:12]
+}
+
+// Diff lists the slugs a reload added, changed and removed, and the
+// version loaded
+type Diff struct {
+	Added   []string `json:"added"`
+	Changed []string `json:"changed"`
+	Removed []string `json:"removed"`
+	Version string   `json:"version"`
+}
+
+// querier is what loading needs from the pool or a transaction
+type querier interface {
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
 }
 
-// Store loaded configs
-var Configs = map[string]*GPTConfig{}
+// LoadConfigs reads and validates the YAML files in dir and the GPTs stored
+// in the gpts table, and replaces the loaded snapshot with them. It is
+// all-or-nothing: if any definition is invalid the previous snapshot stays
+// and the returned ValidationError lists every problem with its file and
+// line. Unchanged configs keep their identity, so state cached per
+// *GPTConfig survives a reload. Changed configs are recorded as new versions.
+func LoadConfigs(ctx context.Context, dir string) (Diff, error) {
+	return load(ctx, dir, 0)
+}
+
+// load is LoadConfigs with the user, if any, who asked for it
+func load(ctx context.Context, dir string, author int) (Diff, error) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	loaded, err := build(ctx, dir, db.PG)
+	if err != nil {
+		return Diff{}, err
+	}
+	if err := recordVersions(ctx, db.PG, loaded, author); err != nil {
+		return Diff{}, err
+	}
+	return swap(loaded), nil
+}
+
+// Update runs change against the gpts table in a transaction and commits it
+// only if every GPT is still valid afterwards. The result is then loaded
+// and announced to the other replicas, with new versions attributed to
+// author. Errors returned by change are passed through untouched.
+func Update(ctx context.Context, dir string, author int, change func(pgx.Tx) error) (Diff, error) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	tx, err := db.PG.Begin(ctx)
+	if err != nil {
+		return Diff{}, err
+	}
+	defer tx.Rollback(ctx)
+	if err := change(tx); err != nil {
+		return Diff{}, err
+	}
+	loaded, err := build(ctx, dir, tx)
+	if err != nil {
+		return Diff{}, err
+	}
+	if err := recordVersions(ctx, tx, loaded, author); err != nil {
+		return Diff{}, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return Diff{}, err
+	}
+	diff := swap(loaded)
+	announce(ctx, diff)
+	return diff, nil
+}
 
-// LoadConfigs reads all YAML files in dir
-func LoadConfigs(dir string) error {
+// build reads and validates every definition without loading it
+func build(ctx context.Context, dir string, q querier) (map[string]*GPTConfig, error) {
 	entries, err := os.ReadDir(dir)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	partials, errs, err := loadPartials(dir)
+	if err != nil {
+		return nil, err
 	}
+	r := newResolver(dir)
+	r.partials = partials
+	var (
+		loaded = map[string]*GPTConfig{}
+		origin = map[string]string{} // slug -> file:line that defined it
+	)
 	for _, f := range entries {
 		if filepath.Ext(f.Name()) != ".yaml" {
 			continue
 		}
 		path := filepath.Join(dir, f.Name())
-		data, err := ioutil.ReadFile(path)
+		data, err := os.ReadFile(path)
 		if err != nil {
-			return err
+			return nil, err
+		}
+		cfg, root, ferrs := parseConfig(path, data, r)
+		if cfg != nil && len(ferrs) == 0 {
+			ferrs = compilePrompt(cfg, r, path, root)
+		}
+		errs = append(errs, ferrs...)
+		if cfg == nil || cfg.Slug == "" {
+			continue
+		}
+		if prev, ok := origin[cfg.Slug]; ok {
+			errs = append(errs, &FieldError{File: path, Line: fieldLine(root, "slug"), Field: "slug",
+				Msg: fmt.Sprintf("%q is already defined at %s", cfg.Slug, prev)})
+			continue
 		}
-		var cfg GPTConfig
-		if err := yaml.Unmarshal(data, &cfg); err != nil {
-			return err
+		origin[cfg.Slug] = fmt.Sprintf("%s:%d", path, fieldLine(root, "slug"))
+		cfg.Source = SourceFile
+		loaded[cfg.Slug] = cfg
+	}
+
+	rows, err := q.Query(ctx, `SELECT slug, definition FROM gpts ORDER BY slug`)
+	if err != nil {
+		return nil, err
+	}
+	stored, err := pgx.CollectRows(rows, pgx.RowToStructByPos[storedGPT])
+	if err != nil {
+		return nil, err
+	}
+	for _, st := range stored {
+		cfg, root, ferrs := parseConfig(StoredName(st.Slug), st.Definition, r)
+		if cfg != nil && len(ferrs) == 0 {
+			ferrs = compilePrompt(cfg, r, StoredName(st.Slug), root)
+		}
+		errs = append(errs, ferrs...)
+		if cfg == nil {
+			continue
+		}
+		if cfg.Slug != st.Slug {
+			errs = append(errs, &FieldError{File: StoredName(st.Slug), Line: fieldLine(root, "slug"), Field: "slug",
+				Msg: fmt.Sprintf("%q doesn't match the stored slug", cfg.Slug)})
+			continue
+		}
+		cfg.Source = SourceDB
+		loaded[cfg.Slug] = cfg
+	}
+	if errs = append(errs, r.errs...); len(errs) > 0 {
+		// a bad inherited field is the same error in every config extending it
+		return nil, errs.unique()
+	}
+	return loaded, nil
+}
+
+// storedGPT is a row of the gpts table
+type storedGPT struct {
+	Slug       string
+	Definition []byte
+}
+
+// StoredName is how errors in a stored definition name it
+func StoredName(slug string) string {
+	return "gpts/" + slug
+}
+
+// swap publishes loaded as the current snapshot and reports what changed
+func swap(loaded map[string]*GPTConfig) Diff {
+	diff := Diff{Added: []string{}, Changed: []string{}, Removed: []string{}}
+	var prev map[string]*GPTConfig
+	if s := current.Load(); s != nil {
+		prev = s.configs
+	}
+	for slug, cfg := range loaded {
+		old, ok := prev[slug]
+		switch {
+		case !ok:
+			diff.Added = append(diff.Added, slug)
+		case old.hash == cfg.hash && old.Source == cfg.Source:
+			loaded[slug] = old
+		default:
+			diff.Changed = append(diff.Changed, slug)
+		}
+	}
+	for slug := range prev {
+		if _, ok := loaded[slug]; !ok {
+			diff.Removed = append(diff.Removed, slug)
 		}
-		Configs[cfg.Slug] = &cfg
 	}
-	return nil
+	slices.Sort(diff.Added)
+	slices.Sort(diff.Changed)
+	slices.Sort(diff.Removed)
+	diff.Version = version(loaded)
+	current.Store(&snapshot{configs: loaded, version: diff.Version})
+	return diff
 }
@@ -0,0 +1,41 @@
+package gpt
+
+import (
+	"github.com/zeelrupapara/custom-ai-server/pkg/i18n"
+)
+
+// Localization is a GPT's user-facing strings in one language. Unset
+// fields fall back to a less specific locale, then to the GPT's own.
+type Localization struct {
+	Name        string   `yaml:"name" json:"name,omitempty"`
+	Description string   `yaml:"description" json:"description,omitempty"`
+	Starters    []string `yaml:"starters" json:"starters,omitempty"`
+	Welcome     string   `yaml:"welcome" json:"welcome,omitempty"`
+}
+
+// Localize returns the GPT's strings for locale: for "de-CH" each field
+// comes from locales "de-ch", then "de", then the GPT itself.
+func (g *GPTConfig) Localize(locale string) Localization {
+	out := Localization{Name: g.Name, Description: g.Description, Starters: g.Starters, Welcome: g.Welcome}
+	chain := i18n.Chain(locale)
+	// least specific first, so closer locales win
+	for i := len(chain) - 1; i >= 0; i-- {
+		l := g.Locales[chain[i]]
+		if l == nil {
+			continue
+		}
+		if l.Name != "" {
+			out.Name = l.Name
+		}
+		if l.Description != "" {
+			out.Description = l.Description
+		}
+		if l.Starters != nil {
+			out.Starters = l.Starters
+		}
+		if l.Welcome != "" {
+			out.Welcome = l.Welcome
+		}
+	}
+	return out
+}
@@ -0,0 +1,266 @@
+package gpt
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template"
+	"text/template/parse"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// partialsDir holds the shared prompt partials, relative to the config dir
+	partialsDir = "partials"
+	// promptName names the system_prompt template itself
+	promptName = "system_prompt"
+)
+
+// PromptVars is everything a system_prompt template can see
+type PromptVars struct {
+	User      PromptUser
+	Org       PromptOrg
+	Date      string // YYYY-MM-DD, UTC
+	Locale    string // BCP 47 tag such as "en" or "de-CH"
+	Language  string // English name of Locale's language, such as "German"
+	Documents []string
+}
+
+// PromptUser is the user being answered
+type PromptUser struct {
+	Name     string // display name, or the username when there is none
+	Username string
+}
+
+// PromptOrg is the user's organization; empty when they have none
+type PromptOrg struct {
+	Name string
+	Slug string
+}
+
+// promptFuncs are the only functions templates may call
+var promptFuncs = template.FuncMap{
+	"join":  strings.Join,
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+	"default": func(def string, v string) string {
+		if v == "" {
+			return def
+		}
+		return v
+	},
+}
+
+// sampleVars render every template once at load, with and without
+// optional values, so mistakes surface before a user hits them
+var sampleVars = []PromptVars{
+	{
+		User:      PromptUser{Name: "Sample User", Username: "sample"},
+		Org:       PromptOrg{Name: "Sample Org", Slug: "sample"},
+		Date:      "2025-01-01",
+		Locale:    "de-CH",
+		Language:  "German",
+		Documents: []string{"sample.pdf"},
+	},
+	{Date: "2025-01-01", Locale: "en", Language: "English"},
+}
+
+// Templated reports whether the system prompt uses any template actions,
+// so it has to be rendered per caller
+func (g *GPTConfig) Templated() bool {
+	return g.prompt != nil
+}
+
+// Prompt renders the GPT's system prompt for vars
+func (g *GPTConfig) Prompt(vars PromptVars) (string, error) {
+	if g.prompt == nil {
+		return g.SystemPrompt, nil
+	}
+	if vars.Date == "" {
+		vars.Date = time.Now().UTC().Format(time.DateOnly)
+	}
+	var b strings.Builder
+	if err := g.prompt.Execute(&b, vars); err != nil {
+		return "", fmt.Errorf("rendering system prompt of %s: %w", g.Slug, err)
+	}
+	return b.String(), nil
+}
+
+// loadPartials reads dir/partials/*.tmpl; each file defines the partial
+// named after it, used as {{template "name" .}}. The file's final newline
+// is dropped so a partial can stand on a line of its own.
+func loadPartials(dir string) (map[string]string, ValidationError, error) {
+	partials := map[string]string{}
+	paths, err := filepath.Glob(filepath.Join(dir, partialsDir, "*.tmpl"))
+	if err != nil {
+		return nil, nil, err
+	}
+	var errs ValidationError
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		text := strings.TrimSuffix(string(data), "\n")
+		if _, err := template.New("").Funcs(promptFuncs).Parse(text); err != nil {
+			_, line, msg := templateError(err)
+			errs = append(errs, &FieldError{File: path, Line: line, Msg: msg})
+			continue
+		}
+		partials[strings.TrimSuffix(filepath.Base(path), ".tmpl")] = text
+	}
+	return partials, errs, nil
+}
+
+// compilePrompt parses cfg's system_prompt, followed by its includes, with
+// the partials it uses and renders it with sampleVars. Errors point into the
+// config file, or into the fragment they are in.
+func compilePrompt(cfg *GPTConfig, r *resolver, path string, root *yaml.Node) ValidationError {
+	node := fieldNode(root, "system_prompt")
+	fail := func(err error) ValidationError {
+		name, n, msg := templateError(err)
+		if slices.Contains(cfg.Includes, name) {
+			return ValidationError{{File: filepath.Join(r.dir, name), Line: n, Msg: msg}}
+		}
+		line := promptLine(node, root, n)
+		if name != promptName {
+			line = promptLine(node, root, 0)
+			msg = fmt.Sprintf("partial %q line %d: %s", name, n, msg)
+		}
+		return ValidationError{{File: r.file(path, node), Line: line, Field: "system_prompt", Msg: msg}}
+	}
+
+	text := cfg.SystemPrompt
+	templates := r.partials
+	if len(cfg.Includes) > 0 {
+		templates = maps.Clone(r.partials)
+		for _, inc := range cfg.Includes {
+			frag, err := r.fragment(inc)
+			if err != nil {
+				return ValidationError{{File: path, Line: fieldLine(root, "includes"), Field: "includes", Msg: err.Error()}}
+			}
+			templates[inc] = frag
+			text = strings.TrimRight(text, "\n") + fmt.Sprintf("\n\n{{template %q .}}", inc)
+		}
+	}
+	tmpl, err := template.New(promptName).Funcs(promptFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return fail(err)
+	}
+	used := map[string]string{}
+	if err := addPartials(tmpl, tmpl.Tree.Root, templates, used); err != nil {
+		if _, line, _ := templateError(err); line > 0 {
+			return fail(err)
+		}
+		return ValidationError{{File: r.file(path, node), Line: fieldLine(root, "system_prompt"), Field: "system_prompt", Msg: err.Error()}}
+	}
+	for _, vars := range sampleVars {
+		if err := tmpl.Execute(new(strings.Builder), vars); err != nil {
+			return fail(err)
+		}
+	}
+	if !plainText(tmpl.Tree.Root) {
+		cfg.prompt = tmpl
+		cfg.partials = used
+	}
+	return nil
+}
+
+// plainText reports whether a parsed template is nothing but text
+func plainText(root *parse.ListNode) bool {
+	for _, n := range root.Nodes {
+		if n.Type() != parse.NodeText {
+			return false
+		}
+	}
+	return true
+}
+
+// addPartials associates every partial node references, directly or
+// through other partials, with tmpl and records it in used
+func addPartials(tmpl *template.Template, node parse.Node, partials, used map[string]string) error {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return nil
+		}
+		for _, c := range n.Nodes {
+			if err := addPartials(tmpl, c, partials, used); err != nil {
+				return err
+			}
+		}
+	case *parse.IfNode:
+		return addBranch(tmpl, &n.BranchNode, partials, used)
+	case *parse.RangeNode:
+		return addBranch(tmpl, &n.BranchNode, partials, used)
+	case *parse.WithNode:
+		return addBranch(tmpl, &n.BranchNode, partials, used)
+	case *parse.TemplateNode:
+		if _, done := used[n.Name]; done {
+			return nil
+		}
+		text, ok := partials[n.Name]
+		if !ok {
+			return fmt.Errorf("unknown partial %q (looked in %s/)", n.Name, partialsDir)
+		}
+		used[n.Name] = text
+		p, err := tmpl.New(n.Name).Parse(text)
+		if err != nil {
+			return err
+		}
+		return addPartials(tmpl, p.Tree.Root, partials, used)
+	}
+	return nil
+}
+
+func addBranch(tmpl *template.Template, b *parse.BranchNode, partials, used map[string]string) error {
+	if err := addPartials(tmpl, b.List, partials, used); err != nil {
+		return err
+	}
+	return addPartials(tmpl, b.ElseList, partials, used)
+}
+
+// fieldNode returns key's value node in m, nil when missing
+func fieldNode(m *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			return m.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// promptLine maps line n of the prompt text to a line of the file. Block
+// scalars (system_prompt: |) start on the line after the indicator.
+func promptLine(node, root *yaml.Node, n int) int {
+	if node == nil {
+		return root.Line
+	}
+	if n < 1 {
+		return node.Line
+	}
+	if node.Style&(yaml.LiteralStyle|yaml.FoldedStyle) != 0 {
+		return node.Line + n
+	}
+	return node.Line + n - 1
+}
+
+var templateErrorPattern = regexp.MustCompile(`^template: ([^:]*):(\d+)(?::\d+)?: (.*)$`)
+
+// templateError splits a text/template error into the template it is in,
+// the line there and the message
+func templateError(err error) (name string, line int, msg string) {
+	m := templateErrorPattern.FindStringSubmatch(err.Error())
+	if m == nil {
+		return promptName, 0, err.Error()
+	}
+	line, _ = strconv.Atoi(m[2])
+	return m[1], line, m[3]
+}
@@ -0,0 +1,135 @@
+package gpt
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// writeDir creates a config dir holding files, keyed by relative path
+func writeDir(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, text := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(text), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// loadFile parses and compiles one config in dir the way build does
+func loadFile(t *testing.T, dir, name string) (*GPTConfig, ValidationError) {
+	t.Helper()
+	partials, errs, err := loadPartials(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := newResolver(dir)
+	r.partials = partials
+	path := filepath.Join(dir, name)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, root, ferrs := parseConfig(path, data, r)
+	if cfg != nil && len(ferrs) == 0 {
+		ferrs = compilePrompt(cfg, r, path, root)
+	}
+	errs = append(errs, ferrs...)
+	return cfg, append(errs, r.errs...)
+}
+
+func mustLoad(t *testing.T, dir, name string) *GPTConfig {
+	t.Helper()
+	cfg, errs := loadFile(t, dir, name)
+	if len(errs) > 0 {
+		t.Fatalf("%s: %v", name, errs)
+	}
+	return cfg
+}
+
+func hashOf(t *testing.T, cfg *GPTConfig) string {
+	t.Helper()
+	_, hash, err := content(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hash
+}
+
+const promptConfig = `slug: xx
+name: X
+model: gpt-4o
+system_prompt: |
+  Hello {{.User.Name}}.
+  {{template "greeting" .}}
+`
+
+func TestPromptPartials(t *testing.T) {
+	files := map[string]string{
+		"x.yaml":                 promptConfig,
+		"partials/greeting.tmpl": "Say hi{{template \"sign-off\" .}}\n",
+		"partials/sign-off.tmpl": ", warmly.\n",
+		"partials/unused.tmpl":   "Never rendered.\n",
+	}
+	cfg := mustLoad(t, writeDir(t, files), "x.yaml")
+	if got := slices.Sorted(maps.Keys(cfg.partials)); !reflect.DeepEqual(got, []string{"greeting", "sign-off"}) {
+		t.Errorf("partials = %q, want the two the prompt uses", got)
+	}
+	got, err := cfg.Prompt(PromptVars{User: PromptUser{Name: "Ada"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "Hello Ada.\nSay hi, warmly.\n"; got != want {
+		t.Errorf("Prompt = %q, want %q", got, want)
+	}
+
+	base := hashOf(t, cfg)
+	files["partials/unused.tmpl"] = "Edited.\n"
+	if h := hashOf(t, mustLoad(t, writeDir(t, files), "x.yaml")); h != base {
+		t.Error("editing an unused partial changed the hash")
+	}
+	files["partials/sign-off.tmpl"] = ", briefly.\n"
+	if h := hashOf(t, mustLoad(t, writeDir(t, files), "x.yaml")); h == base {
+		t.Error("editing a partial the prompt uses kept the hash")
+	}
+}
+
+func TestPromptPlainText(t *testing.T) {
+	dir := writeDir(t, map[string]string{
+		"x.yaml":               "slug: xx\nname: X\nmodel: gpt-4o\nsystem_prompt: Just text.\n",
+		"partials/unused.tmpl": "Never rendered.\n",
+	})
+	cfg := mustLoad(t, dir, "x.yaml")
+	if cfg.Templated() || cfg.partials != nil {
+		t.Errorf("plain prompt: Templated = %v, partials = %q", cfg.Templated(), cfg.partials)
+	}
+}
+
+func TestPromptErrors(t *testing.T) {
+	tests := []struct {
+		name, prompt, want string
+	}{
+		{"unknown partial", `{{template "nope" .}}`, `unknown partial "nope"`},
+		{"unknown variable", `{{.User.Age}}`, "can't evaluate field Age"},
+		{"syntax", `{{.User.Name`, "unclosed action"},
+	}
+	for _, tt := range tests {
+		dir := writeDir(t, map[string]string{
+			"x.yaml": "slug: xx\nname: X\nmodel: gpt-4o\nsystem_prompt: |\n  line one\n  " + tt.prompt + "\n",
+		})
+		_, errs := loadFile(t, dir, "x.yaml")
+		if len(errs) != 1 || !strings.Contains(errs[0].Error(), tt.want) {
+			t.Errorf("%s: errors = %v, want one containing %q", tt.name, errs, tt.want)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package gpt
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+
+	"go.uber.org/zap"
+
+	"github.com/zeelrupapara/custom-ai-server/pkg/db"
+)
+
+// reloadChannel carries reload notices between replicas
+const reloadChannel = "gpt:reload"
+
+// reloadNotice tells other replicas which version a replica just loaded
+type reloadNotice struct {
+	Origin  string `json:"origin"`
+	Version string `json:"version"`
+}
+
+// instanceID tells this replica's own notices apart from the others'
+var instanceID = func() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}()
+
+// Reload loads dir like LoadConfigs, logs the outcome and tells the other
+// replicas to reload too. source says what triggered it, for the log, and
+// author who did, 0 for nobody.
+func Reload(ctx context.Context, dir, source string, author int) (Diff, error) {
+	diff, err := load(ctx, dir, author)
+	if err != nil {
+		zap.L().Warn("GPT config reload rejected", zap.String("source", source), zap.Error(err))
+		return diff, err
+	}
+	zap.L().Info("GPT configs reloaded", zap.String("source", source), zap.String("version", diff.Version),
+		zap.Strings("added", diff.Added), zap.Strings("changed", diff.Changed), zap.Strings("removed", diff.Removed))
+	announce(ctx, diff)
+	return diff, nil
+}
+
+// announce tells the other replicas which version this one loaded
+func announce(ctx context.Context, diff Diff) {
+	msg, _ := json.Marshal(reloadNotice{Origin: instanceID, Version: diff.Version})
+	if err := db.RDB.Publish(ctx, reloadChannel, msg).Err(); err != nil {
+		zap.L().Warn("broadcasting GPT reload failed", zap.Error(err))
+	}
+}
+
+// Subscribe reloads dir whenever another replica announces a version this
+// one doesn't have, until ctx ends. A replica whose files still differ
+// afterwards logs it rather than announcing back, so replicas never echo.
+func Subscribe(ctx context.Context, dir string) {
+	sub := db.RDB.Subscribe(ctx, reloadChannel)
+	defer sub.Close()
+	ch := sub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case m, ok := <-ch:
+			if !ok {
+				return
+			}
+			var n reloadNotice
+			if err := json.Unmarshal([]byte(m.Payload), &n); err != nil || n.Origin == instanceID || n.Version == Version() {
+				continue
+			}
+			diff, err := LoadConfigs(ctx, dir)
+			switch {
+			case err != nil:
+				zap.L().Warn("GPT config reload from peer rejected", zap.String("peer", n.Origin), zap.Error(err))
+			case diff.Version != n.Version:
+				zap.L().Warn("GPT configs differ from peer after reload",
+					zap.String("peer", n.Origin), zap.String("peer_version", n.Version), zap.String("version", diff.Version))
+			default:
+				zap.L().Info("GPT configs reloaded from peer", zap.String("peer", n.Origin), zap.String("version", diff.Version))
+			}
+		}
+	}
+}
@@ -0,0 +1,290 @@
+package gpt
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/zeelrupapara/custom-ai-server/pkg/i18n"
+	"github.com/zeelrupapara/custom-ai-server/pkg/ratelimit"
+)
+
+// ProviderOpenAI is the only provider pkg/ai implements
+const ProviderOpenAI = "openai"
+
+// Conversation starter limits; clients show them as buttons
+const (
+	maxStarters   = 4
+	maxStarterLen = 200
+)
+
+var slugPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{1,62}$`)
+
+// knownModels lists the model families each provider accepts. Dated
+// snapshots such as gpt-4o-2024-08-06 match their family.
+var knownModels = map[string][]string{
+	ProviderOpenAI: {
+		"gpt-4o", "gpt-4o-mini", "gpt-4.1", "gpt-4.1-mini", "gpt-4.1-nano",
+		"gpt-4-turbo", "gpt-4-turbo-preview", "gpt-4-0125-preview", "gpt-4-1106-preview",
+		"gpt-4", "gpt-3.5-turbo", "o1", "o3", "o3-mini", "o4-mini",
+	},
+}
+
+// FieldError is one problem in a config file
+type FieldError struct {
+	File  string
+	Line  int
+	Field string
+	Msg   string
+}
+
+func (e *FieldError) Error() string {
+	if e.Field == "" {
+		return fmt.Sprintf("%s:%d: %s", e.File, e.Line, e.Msg)
+	}
+	return fmt.Sprintf("%s:%d: %s: %s", e.File, e.Line, e.Field, e.Msg)
+}
+
+// ValidationError collects every problem found while loading configs
+type ValidationError []*FieldError
+
+func (v ValidationError) Error() string {
+	lines := make([]string, len(v))
+	for i, e := range v {
+		lines[i] = e.Error()
+	}
+	return "invalid GPT config:\n" + strings.Join(lines, "\n")
+}
+
+// unique drops repeats of an error, keeping the first
+func (v ValidationError) unique() ValidationError {
+	seen := map[string]bool{}
+	out := v[:0:0]
+	for _, e := range v {
+		if msg := e.Error(); !seen[msg] {
+			seen[msg] = true
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// readMapping decodes one file into its top-level mapping node
+func readMapping(path string, data []byte) (*yaml.Node, ValidationError) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, ValidationError{{File: path, Line: yamlErrorLine(err), Msg: err.Error()}}
+	}
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil, ValidationError{{File: path, Line: 1, Msg: "expected a mapping of config fields"}}
+	}
+	return doc.Content[0], nil
+}
+
+// parseConfig decodes one definition, merges in the bases it extends and
+// checks the result. It returns the config (nil if the YAML itself is
+// unreadable or a base is broken) and the merged mapping node used to
+// locate fields. Problems in inherited fields point into the base.
+func parseConfig(path string, data []byte, r *resolver) (*GPTConfig, *yaml.Node, ValidationError) {
+	own, errs := readMapping(path, data)
+	if errs != nil {
+		return nil, nil, errs
+	}
+	root, errs := r.resolve(path, own)
+	if root == nil {
+		return nil, nil, errs
+	}
+	var cfg GPTConfig
+	if err := root.Decode(&cfg); err != nil {
+		return nil, nil, ValidationError{{File: path, Line: yamlErrorLine(err), Msg: err.Error()}}
+	}
+	def, err := definition(own)
+	if err != nil {
+		return nil, nil, ValidationError{{File: path, Line: yamlErrorLine(err), Msg: err.Error()}}
+	}
+	cfg.definition = def
+
+	fail := func(field, format string, args ...any) {
+		errs = append(errs, &FieldError{File: r.file(path, fieldNode(root, field)), Line: fieldLine(root, field),
+			Field: field, Msg: fmt.Sprintf(format, args...)})
+	}
+	required := []struct{ field, value string }{
+		{"slug", cfg.Slug}, {"name", cfg.Name}, {"model", cfg.Model}, {"system_prompt", cfg.SystemPrompt},
+	}
+	for _, r := range required {
+		if strings.TrimSpace(r.value) == "" {
+			fail(r.field, "is required")
+		}
+	}
+	if cfg.Slug != "" && !slugPattern.MatchString(cfg.Slug) {
+		fail("slug", "%q must be 2-63 lowercase letters, digits or dashes", cfg.Slug)
+	}
+	if cfg.Provider == "" {
+		cfg.Provider = ProviderOpenAI
+	}
+	if models, ok := knownModels[cfg.Provider]; !ok {
+		fail("provider", "unknown provider %q", cfg.Provider)
+	} else if cfg.Model != "" && !knownModel(models, cfg.Model) {
+		fail("model", "unknown %s model %q", cfg.Provider, cfg.Model)
+	}
+	if t := cfg.Temperature; t != nil && (*t < 0 || *t > 2) {
+		fail("temperature", "%v is outside 0-2", *t)
+	}
+	if cfg.RateLimit != "" {
+		if _, err := ratelimit.Parse(cfg.RateLimit); err != nil {
+			fail("rate_limit", "%v", err)
+		}
+	}
+	for i, f := range cfg.FilePaths() {
+		if st, err := os.Stat(f); err != nil {
+			errs = append(errs, &FieldError{File: r.file(path, fieldNode(root, "files")), Line: itemLine(root, "files", i),
+				Field: "files", Msg: fmt.Sprintf("%q does not exist", f)})
+		} else if st.IsDir() {
+			errs = append(errs, &FieldError{File: r.file(path, fieldNode(root, "files")), Line: itemLine(root, "files", i),
+				Field: "files", Msg: fmt.Sprintf("%q is a directory", f)})
+		}
+	}
+	if includes := fieldNode(root, "includes"); includes != nil {
+		for i, inc := range cfg.Includes {
+			if _, err := r.fragment(inc); err != nil {
+				errs = append(errs, &FieldError{File: r.file(path, includes.Content[i]), Line: includes.Content[i].Line,
+					Field: "includes", Msg: err.Error()})
+			}
+		}
+	}
+	errs = append(errs, checkStarters(r.file(path, fieldNode(root, "starters")), root, "starters", cfg.Starters)...)
+	if locales := fieldNode(root, "locales"); locales != nil {
+		errs = append(errs, checkLocales(&cfg, r.file(path, locales), locales)...)
+	}
+	if cfg.Icon != "" && !validIcon(cfg.Icon) {
+		fail("icon", "%q must be an http(s) URL or a path starting with /", cfg.Icon)
+	}
+	if cfg.ResponseFormat != nil {
+		if err := cfg.ResponseFormat.compile(); err != nil {
+			fail("response_format", "%v", err)
+		}
+	}
+	switch cfg.Visibility {
+	case "":
+		cfg.Visibility = VisibilityPublic
+	case VisibilityPublic, VisibilityRestricted:
+	default:
+		fail("visibility", "unknown visibility %q", cfg.Visibility)
+	}
+	return &cfg, root, errs
+}
+
+// checkStarters checks the starters listed in mapping m; field names
+// them in errors
+func checkStarters(file string, m *yaml.Node, field string, starters []string) ValidationError {
+	var errs ValidationError
+	if len(starters) > maxStarters {
+		errs = append(errs, &FieldError{File: file, Line: fieldLine(m, "starters"), Field: field,
+			Msg: fmt.Sprintf("at most %d conversation starters", maxStarters)})
+	}
+	for i, st := range starters {
+		if st = strings.TrimSpace(st); st == "" || len(st) > maxStarterLen {
+			errs = append(errs, &FieldError{File: file, Line: itemLine(m, "starters", i), Field: field,
+				Msg: fmt.Sprintf("must be 1-%d characters", maxStarterLen)})
+		}
+	}
+	return errs
+}
+
+// checkLocales checks the language tags and strings under locales and
+// lowercases the tags, which is how Localize looks them up
+func checkLocales(cfg *GPTConfig, file string, locales *yaml.Node) ValidationError {
+	var errs ValidationError
+	byTag := make(map[string]*Localization, len(cfg.Locales))
+	for i := 0; i+1 < len(locales.Content); i += 2 {
+		key, val := locales.Content[i], locales.Content[i+1]
+		field := "locales." + key.Value
+		tag := strings.ToLower(key.Value)
+		switch _, dup := byTag[tag]; {
+		case !i18n.Valid(key.Value):
+			errs = append(errs, &FieldError{File: file, Line: key.Line, Field: "locales",
+				Msg: fmt.Sprintf("%q is not a language tag such as \"de\" or \"pt-BR\"", key.Value)})
+			continue
+		case dup:
+			errs = append(errs, &FieldError{File: file, Line: key.Line, Field: "locales",
+				Msg: fmt.Sprintf("%q is listed twice", key.Value)})
+			continue
+		}
+		l := cfg.Locales[key.Value]
+		if l == nil {
+			l = &Localization{}
+		}
+		byTag[tag] = l
+		errs = append(errs, checkStarters(file, val, field+".starters", l.Starters)...)
+	}
+	cfg.Locales = byTag
+	return errs
+}
+
+// validIcon accepts absolute http(s) URLs and paths on this server
+func validIcon(icon string) bool {
+	u, err := url.Parse(icon)
+	if err != nil {
+		return false
+	}
+	if u.Scheme == "" && u.Host == "" {
+		return strings.HasPrefix(u.Path, "/")
+	}
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+func knownModel(models []string, model string) bool {
+	for _, m := range models {
+		if model == m || strings.HasPrefix(model, m+"-20") {
+			return true
+		}
+	}
+	return false
+}
+
+// fieldLine returns the line of key's value in m, or of m itself when the
+// key is missing
+func fieldLine(m *yaml.Node, key string) int {
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			return m.Content[i+1].Line
+		}
+	}
+	return m.Line
+}
+
+// itemLine returns the line of the i-th entry of the sequence under key
+func itemLine(m *yaml.Node, key string, i int) int {
+	for j := 0; j+1 < len(m.Content); j += 2 {
+		if v := m.Content[j+1]; m.Content[j].Value == key && v.Kind == yaml.SequenceNode && i < len(v.Content) {
+			return v.Content[i].Line
+		}
+	}
+	return fieldLine(m, key)
+}
+
+var yamlLinePattern = regexp.MustCompile(`line (\d+)`)
+
+// yamlErrorLine pulls the line number out of a yaml.v3 error message
+func yamlErrorLine(err error) int {
+	var line int
+	if m := yamlLinePattern.FindStringSubmatch(err.Error()); m != nil {
+		fmt.Sscan(m[1], &line)
+	}
+	return line
+}
+
+// ParseDefinition validates one definition given as YAML or JSON, with
+// the bases and fragments it uses from dir; name labels its errors.
+func ParseDefinition(dir, name string, data []byte) (*GPTConfig, error) {
+	r := newResolver(dir)
+	cfg, _, errs := parseConfig(name, data, r)
+	if errs = append(errs, r.errs...); len(errs) > 0 {
+		return nil, errs
+	}
+	return cfg, nil
+}
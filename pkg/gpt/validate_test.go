@@ -0,0 +1,114 @@
+package gpt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+)
+
+func TestValidIcon(t *testing.T) {
+	tests := []struct {
+		icon string
+		want bool
+	}{
+		{"https://cdn.example.com/icons/doctor.png", true},
+		{"http://example.com/a.svg", true},
+		{"/static/icons/docker.png", true},
+		{"/", true},
+		{"icons/docker.png", false},
+		{"//cdn.example.com/a.png", false},
+		{"https://", false},
+		{"ftp://example.com/a.png", false},
+		{"javascript:alert(1)", false},
+		{"data:image/png;base64,AAAA", false},
+		{"file:///etc/passwd", false},
+		{"http://[::1", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := validIcon(tt.icon); got != tt.want {
+			t.Errorf("validIcon(%q) = %v, want %v", tt.icon, got, tt.want)
+		}
+	}
+}
+
+func TestParseConfigErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		want  FieldError // File is relative to the config dir
+	}{
+		{"missing slug", map[string]string{
+			"x.yaml": "name: X\nmodel: gpt-4o\nsystem_prompt: Hi.\n",
+		}, FieldError{File: "x.yaml", Line: 1, Field: "slug", Msg: "is required"}},
+		{"bad slug", map[string]string{
+			"x.yaml": "slug: Bad_Slug\nname: X\nmodel: gpt-4o\nsystem_prompt: Hi.\n",
+		}, FieldError{File: "x.yaml", Line: 1, Field: "slug", Msg: `"Bad_Slug" must be 2-63 lowercase letters, digits or dashes`}},
+		{"unknown model", map[string]string{
+			"x.yaml": "slug: xx\nname: X\nmodel: gpt-9\nsystem_prompt: Hi.\n",
+		}, FieldError{File: "x.yaml", Line: 3, Field: "model", Msg: `unknown openai model "gpt-9"`}},
+		{"unknown provider", map[string]string{
+			"x.yaml": "slug: xx\nname: X\nmodel: gpt-4o\nsystem_prompt: Hi.\nprovider: acme\n",
+		}, FieldError{File: "x.yaml", Line: 5, Field: "provider", Msg: `unknown provider "acme"`}},
+		{"temperature too high", map[string]string{
+			"x.yaml": "slug: xx\nname: X\nmodel: gpt-4o\nsystem_prompt: Hi.\ntemperature: 2.5\n",
+		}, FieldError{File: "x.yaml", Line: 5, Field: "temperature", Msg: "2.5 is outside 0-2"}},
+		{"negative temperature", map[string]string{
+			"x.yaml": "slug: xx\nname: X\nmodel: gpt-4o\ntemperature: -1\nsystem_prompt: Hi.\n",
+		}, FieldError{File: "x.yaml", Line: 4, Field: "temperature", Msg: "-1 is outside 0-2"}},
+		{"bad rate limit", map[string]string{
+			"x.yaml": "slug: xx\nname: X\nmodel: gpt-4o\nsystem_prompt: Hi.\nrate_limit: 20/w\n",
+		}, FieldError{File: "x.yaml", Line: 5, Field: "rate_limit", Msg: `rate limit "20/w": unknown unit "w"`}},
+		{"missing file", map[string]string{
+			"x.yaml": "slug: xx\nname: X\nmodel: gpt-4o\nsystem_prompt: Hi.\nfiles:\n  - nowhere/a.txt\n  - nowhere/b.txt\n",
+		}, FieldError{File: "x.yaml", Line: 7, Field: "files", Msg: `"nowhere/b.txt" does not exist`}},
+		{"error inherited from a base", map[string]string{
+			"bases/hot.yaml": "model: gpt-4o\n\ntemperature: 3\n",
+			"x.yaml":         "slug: xx\nname: X\nextends: hot\nsystem_prompt: Hi.\n",
+		}, FieldError{File: "bases/hot.yaml", Line: 3, Field: "temperature", Msg: "3 is outside 0-2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := writeDir(t, tt.files)
+			_, errs := loadFile(t, dir, "x.yaml")
+			want := tt.want
+			want.File = filepath.Join(dir, want.File)
+			for _, e := range errs {
+				if *e == want {
+					return
+				}
+			}
+			t.Errorf("errors = %v, want one %v", errs, &want)
+		})
+	}
+}
+
+// noStored is a querier with nothing in the gpts table
+type noStored struct{ querier }
+
+func (noStored) Query(context.Context, string, ...any) (pgx.Rows, error) { return emptyRows{}, nil }
+
+type emptyRows struct{ pgx.Rows }
+
+func (emptyRows) Next() bool { return false }
+func (emptyRows) Err() error { return nil }
+func (emptyRows) Close()     {}
+
+func TestBuildDuplicateSlug(t *testing.T) {
+	const def = "slug: xx\nname: X\nmodel: gpt-4o\nsystem_prompt: Hi.\n"
+	dir := writeDir(t, map[string]string{"ab.yaml": def, "bc.yaml": "\n" + def})
+	_, err := build(context.Background(), dir, noStored{})
+	var errs ValidationError
+	if !errors.As(err, &errs) || len(errs) != 1 {
+		t.Fatalf("build = %v, want one error", err)
+	}
+	want := FieldError{File: filepath.Join(dir, "bc.yaml"), Line: 2, Field: "slug",
+		Msg: fmt.Sprintf(`"xx" is already defined at %s:1`, filepath.Join(dir, "ab.yaml"))}
+	if *errs[0] != want {
+		t.Errorf("error = %v, want %v", errs[0], &want)
+	}
+}
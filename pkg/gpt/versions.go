@@ -0,0 +1,129 @@
+package gpt
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/zeelrupapara/custom-ai-server/pkg/db"
+)
+
+var ErrVersionNotFound = errors.New("GPT version not found")
+
+// VersionInfo is one recorded version of a GPT. Definition is only filled
+// in by GetVersion.
+type VersionInfo struct {
+	Slug       string          `json:"slug"`
+	Version    int             `json:"version"`
+	Hash       string          `json:"hash"`
+	Source     string          `json:"source"`
+	AuthorID   *int            `json:"author_id"`
+	CreatedAt  time.Time       `json:"created_at"`
+	Definition json.RawMessage `json:"definition,omitempty"`
+}
+
+// recordVersions sets the Version of every config in loaded, recording a
+// new version for each one whose content differs from its latest version.
+// Content is the definition plus the text of the partials its prompt
+// uses; see content.
+// author is the user who made the change, 0 when nobody did (startup, the
+// file watcher). Replicas recording the same content concurrently end up
+// sharing one version.
+func recordVersions(ctx context.Context, q querier, loaded map[string]*GPTConfig, author int) error {
+	var authorID *int
+	if author != 0 {
+		authorID = &author
+	}
+	for _, slug := range slices.Sorted(maps.Keys(loaded)) {
+		cfg := loaded[slug]
+		def, hash, err := content(cfg)
+		if err != nil {
+			return err
+		}
+		cfg.hash = hash
+		// a conflict means another replica recorded a version first; look
+		// again until ours is the latest, one way or the other
+		for cfg.Version == 0 {
+			if err := ctx.Err(); err != nil {
+				return fmt.Errorf("recording a version of %s: %w", slug, err)
+			}
+			err := q.QueryRow(ctx,
+				`SELECT version FROM gpt_versions WHERE slug=$1 AND hash=$2
+				 AND version = (SELECT MAX(version) FROM gpt_versions WHERE slug=$1)`, slug, hash).Scan(&cfg.Version)
+			if err == nil {
+				break
+			}
+			if !errors.Is(err, pgx.ErrNoRows) {
+				return fmt.Errorf("looking up the latest version of %s: %w", slug, err)
+			}
+			err = q.QueryRow(ctx,
+				`INSERT INTO gpt_versions(slug,version,hash,definition,source,author_id)
+				 SELECT $1, COALESCE(MAX(version),0)+1, $2, $3, $4, $5 FROM gpt_versions WHERE slug=$1
+				 ON CONFLICT (slug, version) DO NOTHING RETURNING version`,
+				slug, hash, def, cfg.Source, authorID).Scan(&cfg.Version)
+			if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+				return fmt.Errorf("recording a version of %s: %w", slug, err)
+			}
+		}
+	}
+	return nil
+}
+
+// content is what a version of cfg stores, and its hash. Only the partials
+// the prompt references are in it, so editing a partial changes the GPTs
+// that use it and no others.
+func content(cfg *GPTConfig) (json.RawMessage, string, error) {
+	def, err := json.Marshal(versionDefinition{GPTConfig: cfg, Partials: cfg.partials})
+	if err != nil {
+		return nil, "", err
+	}
+	sum := sha256.Sum256(def)
+	return def, hex.EncodeToString(sum[:]), nil
+}
+
+// versionDefinition is what a version stores: the definition and the
+// partials it was rendered with
+type versionDefinition struct {
+	*GPTConfig
+	Partials map[string]string `json:"partials,omitempty"`
+}
+
+// ListVersions returns slug's versions, newest first
+func ListVersions(ctx context.Context, slug string) ([]VersionInfo, error) {
+	rows, err := db.PG.Query(ctx,
+		`SELECT slug, version, hash, source, author_id, created_at FROM gpt_versions
+		 WHERE slug=$1 ORDER BY version DESC`, slug)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(r pgx.CollectableRow) (VersionInfo, error) {
+		var v VersionInfo
+		err := r.Scan(&v.Slug, &v.Version, &v.Hash, &v.Source, &v.AuthorID, &v.CreatedAt)
+		return v, err
+	})
+}
+
+// GetVersion returns one version of slug with its definition; version 0
+// means the latest.
+func GetVersion(ctx context.Context, slug string, version int) (*VersionInfo, error) {
+	var v VersionInfo
+	err := db.PG.QueryRow(ctx,
+		`SELECT slug, version, hash, source, author_id, created_at, definition FROM gpt_versions
+		 WHERE slug=$1 AND ($2=0 OR version=$2) ORDER BY version DESC LIMIT 1`, slug, version).
+		Scan(&v.Slug, &v.Version, &v.Hash, &v.Source, &v.AuthorID, &v.CreatedAt, &v.Definition)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrVersionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
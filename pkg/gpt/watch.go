@@ -0,0 +1,58 @@
+package gpt
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"go.uber.org/zap"
+)
+
+// Watch reloads dir whenever a YAML file in it, or a file in its partials,
+// bases or fragments directory, is written, created, renamed or removed,
+// until ctx ends. Events are debounced so an editor's burst of writes
+// causes one reload; an invalid result is logged and the previous configs
+// stay loaded.
+func Watch(ctx context.Context, dir string, debounce time.Duration) error {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	if err := w.Add(dir); err != nil {
+		return err
+	}
+	for _, sub := range []string{partialsDir, basesDir, fragmentsDir} {
+		if _, err := os.Stat(filepath.Join(dir, sub)); err == nil {
+			if err := w.Add(filepath.Join(dir, sub)); err != nil {
+				return err
+			}
+		}
+	}
+
+	var pending <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case ev, ok := <-w.Events:
+			if !ok {
+				return nil
+			}
+			// anything in a subdirectory counts; at the top only configs do
+			if (filepath.Dir(ev.Name) != filepath.Clean(dir) || filepath.Ext(ev.Name) == ".yaml") && ev.Op&^fsnotify.Chmod != 0 {
+				pending = time.After(debounce)
+			}
+		case err, ok := <-w.Errors:
+			if !ok {
+				return nil
+			}
+			zap.L().Warn("GPT config watcher error", zap.Error(err))
+		case <-pending:
+			pending = nil
+			Reload(ctx, dir, "watch", 0)
+		}
+	}
+}
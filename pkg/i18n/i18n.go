@@ -0,0 +1,143 @@
+// Package i18n holds the messages the server shows users, one catalog per
+// locale in locales/*.json, and matches the locales clients ask for.
+package i18n
+
+import (
+	"cmp"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Default is the locale every message exists in
+const Default = "en"
+
+//go:embed locales/*.json
+var files embed.FS
+
+// catalogs maps a lowercase locale to its messages by key
+var catalogs = load()
+
+func load() map[string]map[string]string {
+	out := map[string]map[string]string{}
+	entries, _ := files.ReadDir("locales")
+	for _, e := range entries {
+		data, err := files.ReadFile("locales/" + e.Name())
+		if err != nil {
+			panic(err)
+		}
+		var msgs map[string]string
+		if err := json.Unmarshal(data, &msgs); err != nil {
+			panic(fmt.Sprintf("i18n: %s: %v", e.Name(), err))
+		}
+		out[strings.ToLower(strings.TrimSuffix(e.Name(), path.Ext(e.Name())))] = msgs
+	}
+	if out[Default] == nil {
+		panic("i18n: no catalog for " + Default)
+	}
+	return out
+}
+
+// tagPattern loosely matches a BCP 47 language tag
+var tagPattern = regexp.MustCompile(`^[A-Za-z]{2,3}(-[A-Za-z0-9]{2,8})*$`)
+
+// Valid reports whether tag looks like a language tag such as "de-CH"
+func Valid(tag string) bool {
+	return tagPattern.MatchString(tag)
+}
+
+// Chain lists tag and its parents, most specific first and lowercased:
+// "de-CH" gives "de-ch", "de". An invalid tag gives nothing.
+func Chain(tag string) []string {
+	if !Valid(tag) {
+		return nil
+	}
+	tag = strings.ToLower(tag)
+	out := []string{tag}
+	for i := strings.LastIndexByte(tag, '-'); i > 0; i = strings.LastIndexByte(tag, '-') {
+		tag = tag[:i]
+		out = append(out, tag)
+	}
+	return out
+}
+
+// Lookup returns the message for key in the closest catalog to locale,
+// falling back to Default. ok is false when no catalog has key.
+func Lookup(locale, key string) (msg string, ok bool) {
+	for _, l := range append(Chain(locale), Default) {
+		if msg, ok := catalogs[l][key]; ok {
+			return msg, true
+		}
+	}
+	return "", false
+}
+
+// T is the message for key in locale formatted with args as by
+// fmt.Sprintf, or key itself when no catalog has it
+func T(locale, key string, args ...any) string {
+	msg, ok := Lookup(locale, key)
+	if !ok {
+		return key
+	}
+	if len(args) > 0 {
+		return fmt.Sprintf(msg, args...)
+	}
+	return msg
+}
+
+// Preferred returns the tag an Accept-Language header ranks highest, or ""
+// when it names none. Wildcards and tags with q=0 are skipped.
+func Preferred(header string) string {
+	type choice struct {
+		tag string
+		q   float64
+	}
+	var choices []choice
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(part, ";")
+		tag = strings.TrimSpace(tag)
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+		if Valid(tag) && q > 0 {
+			choices = append(choices, choice{tag, q})
+		}
+	}
+	if len(choices) == 0 {
+		return ""
+	}
+	// stable, so equal weights keep the client's order
+	slices.SortStableFunc(choices, func(a, b choice) int { return cmp.Compare(b.q, a.q) })
+	return choices[0].tag
+}
+
+// languages names the languages system prompts are most often asked for
+var languages = map[string]string{
+	"ar": "Arabic", "cs": "Czech", "da": "Danish", "de": "German", "el": "Greek",
+	"en": "English", "es": "Spanish", "fi": "Finnish", "fr": "French", "he": "Hebrew",
+	"hi": "Hindi", "hu": "Hungarian", "id": "Indonesian", "it": "Italian", "ja": "Japanese",
+	"ko": "Korean", "nb": "Norwegian", "nl": "Dutch", "no": "Norwegian", "pl": "Polish",
+	"pt": "Portuguese", "ro": "Romanian", "ru": "Russian", "sv": "Swedish", "th": "Thai",
+	"tr": "Turkish", "uk": "Ukrainian", "vi": "Vietnamese", "zh": "Chinese",
+}
+
+// Language is the English name of tag's language, such as "German" for
+// "de-CH", or tag itself when it isn't known
+func Language(tag string) string {
+	chain := Chain(tag)
+	if len(chain) == 0 {
+		return tag
+	}
+	if name, ok := languages[chain[len(chain)-1]]; ok {
+		return name
+	}
+	return tag
+}
@@ -0,0 +1,87 @@
+package i18n
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+func TestPreferred(t *testing.T) {
+	tests := []struct {
+		header, want string
+	}{
+		{"", ""},
+		{"de", "de"},
+		{"de-CH, fr;q=0.9, en;q=0.8", "de-CH"},
+		{"en;q=0.5, fr;q=0.9", "fr"},
+		{"fr;q=0.8, es;q=0.8", "fr"}, // ties keep the client's order
+		{"*, es;q=0.5", "es"},
+		{"de;q=0, en;q=0.1", "en"},
+		{"de;q=0", ""},
+		{"  pt-BR ;q=1 , en", "pt-BR"},
+		{"en;q=abc, de;q=0.9", "en"}, // a bad weight counts as 1
+		{"x, 1234, de", "de"},
+		{"en_US", ""},
+	}
+	for _, tt := range tests {
+		if got := Preferred(tt.header); got != tt.want {
+			t.Errorf("Preferred(%q) = %q, want %q", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestChain(t *testing.T) {
+	tests := []struct {
+		tag  string
+		want []string
+	}{
+		{"de-CH", []string{"de-ch", "de"}},
+		{"zh-Hant-TW", []string{"zh-hant-tw", "zh-hant", "zh"}},
+		{"EN", []string{"en"}},
+		{"", nil},
+		{"de_CH", nil},
+	}
+	for _, tt := range tests {
+		if got := Chain(tt.tag); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Chain(%q) = %q, want %q", tt.tag, got, tt.want)
+		}
+	}
+}
+
+func TestT(t *testing.T) {
+	if got, want := T("de-AT", "busy"), catalogs["de"]["busy"]; got != want || got == catalogs[Default]["busy"] {
+		t.Errorf("T(de-AT, busy) = %q, want the German %q", got, want)
+	}
+	if got := T("xx", "busy"); got != catalogs[Default]["busy"] {
+		t.Errorf("T(xx, busy) = %q, want the %s message", got, Default)
+	}
+	if got := T("de", "no-such-key"); got != "no-such-key" {
+		t.Errorf("T(de, no-such-key) = %q, want the key", got)
+	}
+}
+
+func TestLanguage(t *testing.T) {
+	for tag, want := range map[string]string{"de-CH": "German", "pt-BR": "Portuguese", "EN": "English", "tlh": "tlh", "": ""} {
+		if got := Language(tag); got != want {
+			t.Errorf("Language(%q) = %q, want %q", tag, got, want)
+		}
+	}
+}
+
+// TestCatalogs checks that every catalog translates only known keys and
+// keeps their format verbs
+func TestCatalogs(t *testing.T) {
+	verbs := regexp.MustCompile(`%[-+# 0]*[0-9]*(\.[0-9]+)?[a-zA-Z%]`)
+	for locale, msgs := range catalogs {
+		for key, msg := range msgs {
+			en, ok := catalogs[Default][key]
+			if !ok {
+				t.Errorf("%s: %q is not in the %s catalog", locale, key, Default)
+				continue
+			}
+			if got, want := verbs.FindAllString(msg, -1), verbs.FindAllString(en, -1); !reflect.DeepEqual(got, want) {
+				t.Errorf("%s: %q has verbs %q, %s has %q", locale, key, got, Default, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,453 @@
+// Package jsonschema validates decoded JSON against the subset of JSON
+// Schema that OpenAI structured outputs accept.
+package jsonschema
+
+import (
+	"fmt"
+	"maps"
+	"math"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Schema is a compiled schema, safe for concurrent use
+type Schema struct {
+	root *node
+	defs map[string]*node // by $ref, "#" included
+}
+
+// Error is a value that doesn't match the schema, at a JSON pointer
+type Error struct {
+	Path string
+	Msg  string
+}
+
+func (e *Error) Error() string {
+	if e.Path == "" {
+		return e.Msg
+	}
+	return e.Path + ": " + e.Msg
+}
+
+type node struct {
+	types        []string
+	properties   map[string]*node
+	required     []string
+	additional   *node
+	noAdditional bool
+	items        *node
+	enum         []any
+	constant     any
+	hasConst     bool
+	anyOf        []*node
+	ref          string
+
+	minItems, maxItems, minLength, maxLength     *int
+	minimum, maximum, exclusiveMin, exclusiveMax *float64
+	pattern                                      *regexp.Regexp
+}
+
+var knownTypes = []string{"object", "array", "string", "number", "integer", "boolean", "null"}
+
+// Compile checks schema, as decoded from YAML or JSON, and prepares it for
+// Validate. Keywords outside the supported subset are rejected rather than
+// silently ignored. Strict additionally requires what OpenAI's strict mode
+// does: every object closed with additionalProperties: false and every
+// property required.
+func Compile(schema map[string]any, strict bool) (*Schema, error) {
+	c := compiler{strict: strict}
+	s := &Schema{defs: map[string]*node{}}
+	for _, key := range []string{"$defs", "definitions"} {
+		defs, ok := schema[key]
+		if !ok {
+			continue
+		}
+		m, ok := defs.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("%s: must be an object", at("/"+key))
+		}
+		for _, name := range sortedKeys(m) {
+			n, err := c.compile(m[name], "/"+key+"/"+name)
+			if err != nil {
+				return nil, err
+			}
+			s.defs["#/"+key+"/"+name] = n
+		}
+	}
+	root, err := c.compile(schema, "")
+	if err != nil {
+		return nil, err
+	}
+	for _, ref := range c.refs {
+		if _, ok := s.defs[ref]; !ok && ref != "#" {
+			return nil, fmt.Errorf("$ref %q: no such definition", ref)
+		}
+	}
+	s.root = root
+	s.defs["#"] = root
+	for _, ref := range slices.Sorted(maps.Keys(s.defs)) {
+		if err := s.checkCycle(s.defs[ref], []string{ref}); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// checkCycle rejects references that lead back to one of chain without
+// descending into the value first, which Validate would follow forever.
+// Recursion through properties or items is fine: each step goes deeper.
+func (s *Schema) checkCycle(n *node, chain []string) error {
+	if n.ref != "" {
+		if i := slices.Index(chain, n.ref); i >= 0 {
+			return fmt.Errorf("$ref cycle %s", strings.Join(slices.Concat(chain[i:], []string{n.ref}), " -> "))
+		}
+		if err := s.checkCycle(s.defs[n.ref], append(slices.Clip(chain), n.ref)); err != nil {
+			return err
+		}
+	}
+	for _, sub := range n.anyOf {
+		if err := s.checkCycle(sub, chain); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type compiler struct {
+	strict bool
+	refs   []string
+}
+
+func (c *compiler) compile(v any, path string) (*node, error) {
+	m, ok := v.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("%s: schema must be an object", at(path))
+	}
+	fail := func(key, format string, args ...any) error {
+		return fmt.Errorf("%s: %s", at(path+"/"+key), fmt.Sprintf(format, args...))
+	}
+	n := &node{}
+	for _, key := range sortedKeys(m) {
+		val := m[key]
+		var err error
+		switch key {
+		case "title", "description", "default", "format", "$schema", "$defs", "definitions":
+			// annotations, or handled by Compile
+		case "type":
+			switch t := val.(type) {
+			case string:
+				n.types = []string{t}
+			case []any:
+				for _, e := range t {
+					s, _ := e.(string)
+					n.types = append(n.types, s)
+				}
+			}
+			if len(n.types) == 0 {
+				return nil, fail(key, "must be a type name or a list of them")
+			}
+			for _, t := range n.types {
+				if !slices.Contains(knownTypes, t) {
+					return nil, fail(key, "unknown type %q", t)
+				}
+			}
+		case "properties":
+			props, ok := val.(map[string]any)
+			if !ok {
+				return nil, fail(key, "must be an object")
+			}
+			n.properties = map[string]*node{}
+			for _, name := range sortedKeys(props) {
+				if n.properties[name], err = c.compile(props[name], path+"/properties/"+name); err != nil {
+					return nil, err
+				}
+			}
+		case "required":
+			list, ok := val.([]any)
+			if !ok {
+				return nil, fail(key, "must be a list of property names")
+			}
+			for _, e := range list {
+				s, ok := e.(string)
+				if !ok {
+					return nil, fail(key, "must be a list of property names")
+				}
+				n.required = append(n.required, s)
+			}
+		case "additionalProperties":
+			switch a := val.(type) {
+			case bool:
+				n.noAdditional = !a
+			default:
+				if n.additional, err = c.compile(a, path+"/"+key); err != nil {
+					return nil, err
+				}
+			}
+		case "items":
+			if n.items, err = c.compile(val, path+"/items"); err != nil {
+				return nil, err
+			}
+		case "enum":
+			list, ok := val.([]any)
+			if !ok || len(list) == 0 {
+				return nil, fail(key, "must be a non-empty list")
+			}
+			n.enum = list
+		case "const":
+			n.constant, n.hasConst = val, true
+		case "anyOf":
+			list, ok := val.([]any)
+			if !ok || len(list) == 0 {
+				return nil, fail(key, "must be a non-empty list of schemas")
+			}
+			for i, e := range list {
+				sub, err := c.compile(e, path+"/anyOf/"+strconv.Itoa(i))
+				if err != nil {
+					return nil, err
+				}
+				n.anyOf = append(n.anyOf, sub)
+			}
+		case "$ref":
+			ref, _ := val.(string)
+			if ref != "#" && !strings.HasPrefix(ref, "#/$defs/") && !strings.HasPrefix(ref, "#/definitions/") {
+				return nil, fail(key, "only local references (#, #/$defs/...) are supported")
+			}
+			n.ref = ref
+			c.refs = append(c.refs, ref)
+		case "minItems", "maxItems", "minLength", "maxLength":
+			f, ok := number(val)
+			if !ok || f < 0 || f != math.Trunc(f) {
+				return nil, fail(key, "must be a non-negative integer")
+			}
+			i := int(f)
+			switch key {
+			case "minItems":
+				n.minItems = &i
+			case "maxItems":
+				n.maxItems = &i
+			case "minLength":
+				n.minLength = &i
+			case "maxLength":
+				n.maxLength = &i
+			}
+		case "minimum", "maximum", "exclusiveMinimum", "exclusiveMaximum":
+			f, ok := number(val)
+			if !ok {
+				return nil, fail(key, "must be a number")
+			}
+			switch key {
+			case "minimum":
+				n.minimum = &f
+			case "maximum":
+				n.maximum = &f
+			case "exclusiveMinimum":
+				n.exclusiveMin = &f
+			case "exclusiveMaximum":
+				n.exclusiveMax = &f
+			}
+		case "pattern":
+			s, _ := val.(string)
+			if n.pattern, err = regexp.Compile(s); err != nil {
+				return nil, fail(key, "%v", err)
+			}
+		default:
+			return nil, fail(key, "unsupported keyword")
+		}
+	}
+	for _, r := range n.required {
+		if _, ok := n.properties[r]; !ok && n.properties != nil {
+			return nil, fmt.Errorf("%s: %q is not a property", at(path+"/required"), r)
+		}
+	}
+	if c.strict && slices.Contains(n.types, "object") {
+		if !n.noAdditional {
+			return nil, fmt.Errorf("%s: strict schemas need additionalProperties: false", at(path))
+		}
+		for _, name := range slices.Sorted(maps.Keys(n.properties)) {
+			if !slices.Contains(n.required, name) {
+				return nil, fmt.Errorf("%s: strict schemas must require every property; %q is optional", at(path), name)
+			}
+		}
+	}
+	return n, nil
+}
+
+// Validate checks v, as decoded by encoding/json into an any, and returns
+// the first mismatch as an *Error
+func (s *Schema) Validate(v any) error {
+	return s.validate(s.root, v, "")
+}
+
+func (s *Schema) validate(n *node, v any, path string) error {
+	if n.ref != "" {
+		if err := s.validate(s.defs[n.ref], v, path); err != nil {
+			return err
+		}
+	}
+	fail := func(format string, args ...any) error {
+		return &Error{Path: path, Msg: fmt.Sprintf(format, args...)}
+	}
+	if len(n.types) > 0 && !slices.ContainsFunc(n.types, func(t string) bool { return isType(v, t) }) {
+		return fail("expected %s, got %s", strings.Join(n.types, " or "), typeOf(v))
+	}
+	if n.enum != nil && !slices.ContainsFunc(n.enum, func(e any) bool { return equal(e, v) }) {
+		return fail("not one of the allowed values")
+	}
+	if n.hasConst && !equal(n.constant, v) {
+		return fail("must be %v", n.constant)
+	}
+	if n.anyOf != nil && !slices.ContainsFunc(n.anyOf, func(sub *node) bool { return s.validate(sub, v, path) == nil }) {
+		return fail("matches none of the allowed schemas")
+	}
+
+	switch v := v.(type) {
+	case map[string]any:
+		for _, r := range n.required {
+			if _, ok := v[r]; !ok {
+				return fail("missing property %q", r)
+			}
+		}
+		for _, k := range sortedKeys(v) {
+			p, ok := n.properties[k]
+			switch {
+			case ok:
+			case n.additional != nil:
+				p = n.additional
+			case n.noAdditional:
+				return fail("unexpected property %q", k)
+			default:
+				continue
+			}
+			if err := s.validate(p, v[k], path+"/"+k); err != nil {
+				return err
+			}
+		}
+	case []any:
+		if n.minItems != nil && len(v) < *n.minItems {
+			return fail("needs at least %d items", *n.minItems)
+		}
+		if n.maxItems != nil && len(v) > *n.maxItems {
+			return fail("allows at most %d items", *n.maxItems)
+		}
+		if n.items != nil {
+			for i, e := range v {
+				if err := s.validate(n.items, e, path+"/"+strconv.Itoa(i)); err != nil {
+					return err
+				}
+			}
+		}
+	case string:
+		runes := len([]rune(v))
+		if n.minLength != nil && runes < *n.minLength {
+			return fail("shorter than %d characters", *n.minLength)
+		}
+		if n.maxLength != nil && runes > *n.maxLength {
+			return fail("longer than %d characters", *n.maxLength)
+		}
+		if n.pattern != nil && !n.pattern.MatchString(v) {
+			return fail("doesn't match %s", n.pattern)
+		}
+	case float64:
+		switch {
+		case n.minimum != nil && v < *n.minimum:
+			return fail("less than %v", *n.minimum)
+		case n.maximum != nil && v > *n.maximum:
+			return fail("greater than %v", *n.maximum)
+		case n.exclusiveMin != nil && v <= *n.exclusiveMin:
+			return fail("must be greater than %v", *n.exclusiveMin)
+		case n.exclusiveMax != nil && v >= *n.exclusiveMax:
+			return fail("must be less than %v", *n.exclusiveMax)
+		}
+	}
+	return nil
+}
+
+func isType(v any, t string) bool {
+	switch t {
+	case "integer":
+		f, ok := v.(float64)
+		return ok && f == math.Trunc(f)
+	case "number":
+		_, ok := v.(float64)
+		return ok
+	}
+	return typeOf(v) == t
+}
+
+func typeOf(v any) string {
+	switch v.(type) {
+	case map[string]any:
+		return "object"
+	case []any:
+		return "array"
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case bool:
+		return "boolean"
+	case nil:
+		return "null"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+// equal compares a schema value (decoded from YAML, so ints may be int)
+// with a JSON value
+func equal(a, b any) bool {
+	if x, ok := number(a); ok {
+		y, ok := number(b)
+		return ok && x == y
+	}
+	switch a := a.(type) {
+	case []any:
+		b, ok := b.([]any)
+		return ok && slices.EqualFunc(a, b, equal)
+	case map[string]any:
+		b, ok := b.(map[string]any)
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for k, v := range a {
+			if w, ok := b[k]; !ok || !equal(v, w) {
+				return false
+			}
+		}
+		return true
+	}
+	return a == b
+}
+
+// number reads the numeric types YAML and JSON decode to
+func number(v any) (float64, bool) {
+	switch v := v.(type) {
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+func sortedKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// at names a schema location for errors
+func at(path string) string {
+	if path == "" {
+		return "schema"
+	}
+	return "schema " + path
+}
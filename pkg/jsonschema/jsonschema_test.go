@@ -0,0 +1,135 @@
+package jsonschema
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// schema decodes a schema written as JSON
+func schema(t *testing.T, text string) map[string]any {
+	t.Helper()
+	var m map[string]any
+	if err := json.Unmarshal([]byte(text), &m); err != nil {
+		t.Fatalf("%s: %v", text, err)
+	}
+	return m
+}
+
+func TestValidate(t *testing.T) {
+	const person = `{
+		"type": "object",
+		"properties": {
+			"name": {"type": "string", "minLength": 1},
+			"age": {"type": "integer", "minimum": 0},
+			"mood": {"enum": ["happy", "sad", 3]},
+			"tags": {"type": "array", "items": {"type": "string"}, "maxItems": 2},
+			"nick": {"type": ["string", "null"]}
+		},
+		"required": ["name"]
+	}`
+	const tree = `{
+		"$defs": {
+			"node": {
+				"type": "object",
+				"properties": {
+					"value": {"type": "number"},
+					"children": {"type": "array", "items": {"$ref": "#/$defs/node"}}
+				},
+				"required": ["value"],
+				"additionalProperties": false
+			}
+		},
+		"type": "object",
+		"properties": {"root": {"$ref": "#/$defs/node"}},
+		"additionalProperties": false
+	}`
+	const list = `{
+		"type": "object",
+		"properties": {"next": {"anyOf": [{"$ref": "#"}, {"type": "null"}]}, "v": {"const": 1}}
+	}`
+	tests := []struct {
+		schema, value string
+		err           string // "" when value is valid
+	}{
+		{person, `{"name": "Ada"}`, ""},
+		{person, `{"name": "Ada", "age": 36, "mood": 3, "tags": ["a"], "nick": null, "extra": true}`, ""},
+		{person, `{}`, `missing property "name"`},
+		{person, `[]`, "expected object, got array"},
+		{person, `{"name": ""}`, "/name: shorter than 1 characters"},
+		{person, `{"name": "Ada", "age": 3.5}`, "/age: expected integer, got number"},
+		{person, `{"name": "Ada", "age": -1}`, "/age: less than 0"},
+		{person, `{"name": "Ada", "mood": "angry"}`, "/mood: not one of the allowed values"},
+		{person, `{"name": "Ada", "mood": "3"}`, "/mood: not one of the allowed values"},
+		{person, `{"name": "Ada", "tags": ["a", 1]}`, "/tags/1: expected string, got number"},
+		{person, `{"name": "Ada", "tags": ["a", "b", "c"]}`, "/tags: allows at most 2 items"},
+		{person, `{"name": "Ada", "nick": 1}`, "/nick: expected string or null, got number"},
+
+		{tree, `{"root": {"value": 1, "children": [{"value": 2, "children": []}]}}`, ""},
+		{tree, `{"root": {"value": 1, "children": [{"children": []}]}}`, `/root/children/0: missing property "value"`},
+		{tree, `{"root": {"value": 1, "colour": "red"}}`, `/root: unexpected property "colour"`},
+		{tree, `{"other": 1}`, `unexpected property "other"`},
+
+		{list, `{"v": 1, "next": {"v": 1, "next": null}}`, ""},
+		{list, `{"v": 1, "next": {"v": 2}}`, "/next: matches none of the allowed schemas"},
+		{list, `{"v": 2}`, "/v: must be 1"},
+	}
+	for _, tt := range tests {
+		s, err := Compile(schema(t, tt.schema), false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var v any
+		if err := json.Unmarshal([]byte(tt.value), &v); err != nil {
+			t.Fatal(err)
+		}
+		err = s.Validate(v)
+		switch {
+		case tt.err == "" && err != nil:
+			t.Errorf("Validate(%s): %v", tt.value, err)
+		case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+			t.Errorf("Validate(%s) = %v, want an error containing %q", tt.value, err, tt.err)
+		}
+	}
+}
+
+func TestCompile(t *testing.T) {
+	tests := []struct {
+		schema string
+		strict bool
+		err    string // "" when the schema compiles
+	}{
+		{`{"type": "object", "properties": {"a": {"type": "string"}}, "required": ["a"], "additionalProperties": false}`, true, ""},
+		{`{"type": "object", "properties": {"a": {"type": "string"}}, "required": ["a"]}`, true, "strict schemas need additionalProperties: false"},
+		{`{"type": "object", "properties": {"a": {"type": "string"}}, "additionalProperties": false}`, true, `"a" is optional`},
+		{`{"type": "object", "properties": {"a": {"type": "object"}}, "required": ["a"], "additionalProperties": false}`, true, "schema /properties/a: strict schemas need"},
+		{`{"type": "object", "properties": {"a": {"type": "string"}}}`, false, ""},
+		{`{"type": "object", "required": ["b"], "properties": {"a": {}}}`, false, `"b" is not a property`},
+		{`{"type": "text"}`, false, `unknown type "text"`},
+		{`{"type": []}`, false, "must be a type name or a list of them"},
+		{`{"enum": []}`, false, "must be a non-empty list"},
+		{`{"minLength": -1}`, false, "must be a non-negative integer"},
+		{`{"pattern": "("}`, false, "schema /pattern:"},
+		{`{"oneOf": [{}]}`, false, "unsupported keyword"},
+		{`{"$ref": "http://example.com/s.json"}`, false, "only local references"},
+		{`{"$ref": "#/$defs/missing"}`, false, `"#/$defs/missing": no such definition`},
+
+		// references that never descend into the value loop forever
+		{`{"$ref": "#"}`, false, "$ref cycle # -> #"},
+		{`{"$defs": {"a": {"$ref": "#/$defs/b"}, "b": {"$ref": "#/$defs/a"}}, "$ref": "#/$defs/a"}`, false,
+			"$ref cycle #/$defs/a -> #/$defs/b -> #/$defs/a"},
+		{`{"$defs": {"a": {"anyOf": [{"type": "null"}, {"$ref": "#/$defs/a"}]}}, "type": "object"}`, false,
+			"$ref cycle #/$defs/a -> #/$defs/a"},
+		{`{"definitions": {"a": {"type": "array", "items": {"$ref": "#/definitions/a"}}}, "$ref": "#/definitions/a"}`, false, ""},
+		{`{"type": "object", "properties": {"self": {"$ref": "#"}}}`, false, ""},
+	}
+	for _, tt := range tests {
+		_, err := Compile(schema(t, tt.schema), tt.strict)
+		switch {
+		case tt.err == "" && err != nil:
+			t.Errorf("Compile(%s): %v", tt.schema, err)
+		case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+			t.Errorf("Compile(%s) = %v, want an error containing %q", tt.schema, err, tt.err)
+		}
+	}
+}
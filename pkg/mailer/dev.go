@@ -0,0 +1,50 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Log writes messages to the application log instead of sending them.
+// Meant for local development: bodies contain live tokens.
+type Log struct{}
+
+// Send logs m
+func (Log) Send(_ context.Context, m Message) error {
+	zap.L().Info("email (not sent)", zap.String("to", m.To), zap.String("subject", m.Subject), zap.String("body", m.Body))
+	return nil
+}
+
+// File writes each message as an .eml file in Dir, which most mail
+// clients can open.
+type File struct {
+	Dir  string
+	From string
+}
+
+// Send writes m to a new file in f.Dir
+func (f *File) Send(_ context.Context, m Message) error {
+	if err := os.MkdirAll(f.Dir, 0o700); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s-%s.eml", time.Now().UTC().Format("20060102T150405.000000000"), sanitize(m.To))
+	return os.WriteFile(filepath.Join(f.Dir, name), m.rfc822(f.From), 0o600)
+}
+
+// sanitize keeps an address usable as part of a file name
+func sanitize(s string) string {
+	b := []byte(s)
+	for i, c := range b {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '@', c == '.', c == '-', c == '_':
+		default:
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
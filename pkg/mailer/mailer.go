@@ -0,0 +1,64 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"mime"
+	"time"
+
+	"github.com/zeelrupapara/custom-ai-server/pkg/config"
+)
+
+// Message is a plain-text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers email
+type Mailer interface {
+	Send(ctx context.Context, m Message) error
+}
+
+// Default is the mailer chosen by MAILER; set by Setup
+var Default Mailer = Log{}
+
+// Setup picks the mailer from config: "smtp", "file" or "log" (default)
+func Setup() error {
+	cfg := config.Load()
+	switch cfg.Mailer {
+	case "smtp":
+		if cfg.SMTPAddr == "" {
+			return fmt.Errorf("MAILER=smtp needs SMTP_ADDR")
+		}
+		Default = &SMTP{Addr: cfg.SMTPAddr, Username: cfg.SMTPUsername, Password: cfg.SMTPPassword, From: cfg.MailFrom}
+	case "file":
+		Default = &File{Dir: cfg.MailDir, From: cfg.MailFrom}
+	case "log", "":
+		Default = Log{}
+	default:
+		return fmt.Errorf("unknown MAILER %q", cfg.Mailer)
+	}
+	return nil
+}
+
+// Send delivers m with the Default mailer
+func Send(ctx context.Context, m Message) error {
+	return Default.Send(ctx, m)
+}
+
+// rfc822 renders m with the headers every transport needs
+func (m Message) rfc822(from string) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", m.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", m.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+	b.WriteString(m.Body)
+	return b.Bytes()
+}
@@ -0,0 +1,151 @@
+package org
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+
+	"github.com/zeelrupapara/custom-ai-server/pkg/db"
+)
+
+// Member roles within an organization
+const (
+	RoleMember = "member"
+	RoleAdmin  = "admin"
+)
+
+var (
+	ErrNotFound      = errors.New("organization not found")
+	ErrQuotaExceeded = errors.New("organization quota exceeded")
+)
+
+// Org is a tenant. Nil quotas are unlimited.
+type Org struct {
+	ID              int       `json:"id"`
+	Slug            string    `json:"slug"`
+	Name            string    `json:"name"`
+	MaxUsers        *int      `json:"max_users"`
+	MonthlyMessages *int      `json:"monthly_messages"`
+	MaxUploadBytes  *int64    `json:"max_upload_bytes"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+// Usage is an organization's consumption against its quotas
+type Usage struct {
+	Users       int   `json:"users"`
+	Messages    int64 `json:"messages_this_month"`
+	UploadBytes int64 `json:"upload_bytes"`
+}
+
+const columns = `id, slug, name, max_users, monthly_messages, max_upload_bytes, created_at`
+
+func scan(r pgx.Row) (*Org, error) {
+	var o Org
+	err := r.Scan(&o.ID, &o.Slug, &o.Name, &o.MaxUsers, &o.MonthlyMessages, &o.MaxUploadBytes, &o.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return &o, err
+}
+
+// Get returns the organization with id
+func Get(ctx context.Context, id int) (*Org, error) {
+	return scan(db.PG.QueryRow(ctx, `SELECT `+columns+` FROM organizations WHERE id=$1`, id))
+}
+
+// BySlug returns the organization with slug
+func BySlug(ctx context.Context, slug string) (*Org, error) {
+	return scan(db.PG.QueryRow(ctx, `SELECT `+columns+` FROM organizations WHERE slug=$1`, slug))
+}
+
+// List returns every organization
+func List(ctx context.Context) ([]*Org, error) {
+	rows, err := db.PG.Query(ctx, `SELECT `+columns+` FROM organizations ORDER BY slug`)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(r pgx.CollectableRow) (*Org, error) { return scan(r) })
+}
+
+// messagesKey counts an organization's messages in the current month
+func messagesKey(orgID int) string {
+	return fmt.Sprintf("quota:org:%d:messages:%s", orgID, time.Now().UTC().Format("200601"))
+}
+
+// CheckMessages returns ErrQuotaExceeded once orgID has used up its
+// monthly message quota. It charges nothing; see CountMessage. Errors fail
+// open.
+func CheckMessages(ctx context.Context, orgID int) error {
+	if orgID == 0 {
+		return nil
+	}
+	var quota *int
+	err := db.PG.QueryRow(ctx, `SELECT monthly_messages FROM organizations WHERE id=$1`, orgID).Scan(&quota)
+	if err != nil || quota == nil {
+		return nil
+	}
+	used, err := db.RDB.Get(ctx, messagesKey(orgID)).Int64()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		zap.L().Warn("org message quota check failed", zap.Int("org_id", orgID), zap.Error(err))
+		return nil
+	}
+	if used >= int64(*quota) {
+		return ErrQuotaExceeded
+	}
+	return nil
+}
+
+// CountMessage charges one answered chat message to orgID
+func CountMessage(ctx context.Context, orgID int) {
+	if orgID == 0 {
+		return
+	}
+	key := messagesKey(orgID)
+	pipe := db.RDB.TxPipeline()
+	pipe.Incr(ctx, key)
+	pipe.ExpireNX(ctx, key, 32*24*time.Hour)
+	if _, err := pipe.Exec(ctx); err != nil {
+		zap.L().Warn("org message count failed", zap.Int("org_id", orgID), zap.Error(err))
+	}
+}
+
+// CheckUpload returns ErrQuotaExceeded when storing size more bytes would
+// take orgID past its upload quota.
+func CheckUpload(ctx context.Context, orgID int, size int64) error {
+	if orgID == 0 {
+		return nil
+	}
+	var (
+		quota *int64
+		used  int64
+	)
+	err := db.PG.QueryRow(ctx,
+		`SELECT o.max_upload_bytes, COALESCE((SELECT SUM(size) FROM uploads WHERE org_id=o.id), 0)
+		 FROM organizations o WHERE o.id=$1`, orgID).Scan(&quota, &used)
+	if err != nil {
+		return err
+	}
+	if quota != nil && used+size > *quota {
+		return ErrQuotaExceeded
+	}
+	return nil
+}
+
+// GetUsage reports orgID's consumption
+func GetUsage(ctx context.Context, orgID int) (Usage, error) {
+	var u Usage
+	err := db.PG.QueryRow(ctx,
+		`SELECT (SELECT COUNT(*) FROM users WHERE org_id=$1),
+		        COALESCE((SELECT SUM(size) FROM uploads WHERE org_id=$1), 0)`, orgID).
+		Scan(&u.Users, &u.UploadBytes)
+	if err != nil {
+		return u, err
+	}
+	u.Messages, _ = db.RDB.Get(ctx, messagesKey(orgID)).Int64()
+	return u, nil
+}
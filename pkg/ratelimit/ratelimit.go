@@ -0,0 +1,57 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zeelrupapara/custom-ai-server/pkg/db"
+)
+
+// Limit is a parsed rate such as "20/m": Count events per Window.
+type Limit struct {
+	Count  int
+	Window time.Duration
+}
+
+// Parse reads "N/unit" where unit is s, m, h or d (or sec, min, hour, day).
+func Parse(s string) (Limit, error) {
+	n, unit, ok := strings.Cut(strings.TrimSpace(s), "/")
+	if !ok {
+		return Limit{}, fmt.Errorf("rate limit %q: want N/unit, e.g. 20/m", s)
+	}
+	count, err := strconv.Atoi(strings.TrimSpace(n))
+	if err != nil || count <= 0 {
+		return Limit{}, fmt.Errorf("rate limit %q: count must be a positive integer", s)
+	}
+	var window time.Duration
+	switch strings.ToLower(strings.TrimSpace(unit)) {
+	case "s", "sec", "second":
+		window = time.Second
+	case "m", "min", "minute":
+		window = time.Minute
+	case "h", "hour":
+		window = time.Hour
+	case "d", "day":
+		window = 24 * time.Hour
+	default:
+		return Limit{}, fmt.Errorf("rate limit %q: unknown unit %q", s, unit)
+	}
+	return Limit{Count: count, Window: window}, nil
+}
+
+// Hit records one event for key in the current fixed window and returns
+// how many events the window now holds. Callers compare it with l.Count.
+func Hit(ctx context.Context, key string, l Limit) (int, error) {
+	window := time.Now().UnixNano() / int64(l.Window)
+	k := fmt.Sprintf("ratelimit:%s:%d", key, window)
+	pipe := db.RDB.TxPipeline()
+	incr := pipe.Incr(ctx, k)
+	pipe.Expire(ctx, k, l.Window)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+	return int(incr.Val()), nil
+}
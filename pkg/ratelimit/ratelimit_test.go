@@ -0,0 +1,42 @@
+package ratelimit
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Limit
+		err  string
+	}{
+		{in: "20/m", want: Limit{20, time.Minute}},
+		{in: " 5 / sec ", want: Limit{5, time.Second}},
+		{in: "100/Hour", want: Limit{100, time.Hour}},
+		{in: "1/d", want: Limit{1, 24 * time.Hour}},
+		{in: "1/day", want: Limit{1, 24 * time.Hour}},
+		{in: "20", err: "want N/unit"},
+		{in: "", err: "want N/unit"},
+		{in: "0/m", err: "positive integer"},
+		{in: "-3/m", err: "positive integer"},
+		{in: "x/m", err: "positive integer"},
+		{in: "1.5/m", err: "positive integer"},
+		{in: "20/w", err: `unknown unit "w"`},
+		{in: "20/", err: "unknown unit"},
+	}
+	for _, tt := range tests {
+		got, err := Parse(tt.in)
+		switch {
+		case tt.err != "":
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("Parse(%q) error = %v, want one containing %q", tt.in, err, tt.err)
+			}
+		case err != nil:
+			t.Errorf("Parse(%q): %v", tt.in, err)
+		case got != tt.want:
+			t.Errorf("Parse(%q) = %+v, want %+v", tt.in, got, tt.want)
+		}
+	}
+}